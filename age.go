@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// recipientsFileName is looked for in the secrets file's directory and its
+// parents when no recipients are given, so a team can version who can
+// decrypt alongside the secrets.
+const recipientsFileName = ".unseal-recipients"
+
+// ageCipher encrypts groups with the age command. Without recipients or an
+// identity age asks for a passphrase on the terminal.
+type ageCipher struct {
+	recipients []string
+	identity   string
+	// recipientsFile lists recipients, one per line, used when none are
+	// given on the command line.
+	recipientsFile string
+}
+
+func (ageCipher) ext() string {
+	return ".age"
+}
+
+func (a ageCipher) encrypt(plaintext []byte) ([]byte, error) {
+	recipients := a.recipients
+	if len(recipients) == 0 {
+		var err error
+		recipients, err = readRecipients(a.recipientsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	args := []string{"--armor"}
+	if len(recipients) == 0 {
+		args = append(args, "--passphrase")
+	}
+	for _, r := range recipients {
+		args = append(args, "--recipient", r)
+	}
+
+	return age(bytes.NewReader(plaintext), args...)
+}
+
+func (a ageCipher) decrypt(ciphertext []byte) ([]byte, error) {
+	return age(bytes.NewReader(ciphertext), a.decryptArgs()...)
+}
+
+func (a ageCipher) decryptStream(ciphertext io.Reader, w io.Writer) error {
+	c := exec.Command("age", a.decryptArgs()...)
+	c.Stdin = ciphertext
+	c.Stdout = w
+	c.Stderr = os.Stderr
+
+	return c.Run()
+}
+
+func (a ageCipher) decryptArgs() []string {
+	args := []string{"--decrypt"}
+	if a.identity != "" {
+		args = append(args, "--identity", a.identity)
+	}
+
+	return args
+}
+
+// readRecipients returns the recipients listed in path, or in the nearest
+// recipientsFileName above the secrets file when path is empty. Blank lines
+// and comments are skipped.
+func readRecipients(path string) ([]string, error) {
+	if path == "" {
+		var err error
+		path, err = findRecipientsFile(recipientsSearchDir())
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			return nil, nil
+		}
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read recipients: %v", err)
+	}
+
+	var recipients []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			recipients = append(recipients, line)
+		}
+	}
+
+	return recipients, nil
+}
+
+// recipientsSearchDir is where the search for recipientsFileName starts: the
+// directory of the group's secrets file.
+func recipientsSearchDir() string {
+	if secretsFile == stdioGroup {
+		return secretsDir
+	}
+
+	return filepath.Dir(secretsFile)
+}
+
+// findRecipientsFile walks up from dir looking for recipientsFileName. It
+// stops at the secrets directory or the root of a git repository, and refuses
+// a file that someone else could have written.
+func findRecipientsFile(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	top, err := filepath.Abs(secretsDir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		path := filepath.Join(dir, recipientsFileName)
+		if fileExists(path) {
+			err := checkOwnedFile(path)
+			if err != nil {
+				return "", fmt.Errorf("Refusing recipients file %s: %v", path, err)
+			}
+			return path, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if dir == top || parent == dir || fileExists(filepath.Join(dir, ".git")) {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+func age(stdin io.Reader, args ...string) ([]byte, error) {
+	c := exec.Command("age", args...)
+	c.Stdin = stdin
+
+	stdout, stderr, err := run(c, false)
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, stderr)
+	}
+
+	return stdout, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// auditFile is the -audit-log, opened before any command runs so that a log
+// that can't be written stops unseal instead of going unrecorded.
+var auditFile *os.File
+
+// auditRecord is one line of the audit log. It never contains secrets.
+type auditRecord struct {
+	Time    string `json:"time"`
+	User    string `json:"user"`
+	Command string `json:"command"`
+	Group   string `json:"group,omitempty"`
+	Status  string `json:"status"`
+	Exit    int    `json:"exit"`
+}
+
+func openAuditLog(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, mode)
+	if err != nil {
+		return err
+	}
+
+	auditFile = f
+	return nil
+}
+
+// writeAudit appends the outcome of this invocation to the audit log.
+func writeAudit(code int) {
+	if auditFile == nil {
+		return
+	}
+
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	status := "success"
+	if code != 0 {
+		status = "failure"
+	}
+
+	line, err := json.Marshal(auditRecord{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		User:    name,
+		Command: cmd,
+		Group:   group,
+		Status:  status,
+		Exit:    code,
+	})
+	if err == nil {
+		_, err = auditFile.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = auditFile.Sync()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing audit log: ", err)
+	}
+
+	auditFile.Close()
+	auditFile = nil
+}
+
+// exit records the outcome in the audit log and exits with code.
+func exit(code int) {
+	writeAudit(code)
+	os.Exit(code)
+}
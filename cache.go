@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheDir returns the directory holding cached plaintext, preferring a tmpfs
+// so that it never reaches the disk.
+func cacheDir() string {
+	base := os.TempDir()
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		base = "/dev/shm"
+	}
+
+	return filepath.Join(base, fmt.Sprintf("unseal-%d", os.Getuid()))
+}
+
+func cachePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	sum := sha256.Sum256([]byte(abs))
+
+	return filepath.Join(cacheDir(), hex.EncodeToString(sum[:]))
+}
+
+// cacheKey identifies the version of the secrets file a cache entry was made
+// from.
+func cacheKey(info os.FileInfo) string {
+	return fmt.Sprintf("%d %d\n", info.ModTime().UnixNano(), info.Size())
+}
+
+// readCache returns the cached plaintext of path if it was stored less than
+// -cache ago and the secrets file hasn't changed since.
+func readCache(path string, info os.FileInfo) ([]byte, bool) {
+	cache := cachePath(path)
+	cacheInfo, err := os.Stat(cache)
+	if err != nil {
+		return nil, false
+	}
+
+	if time.Since(cacheInfo.ModTime()) > cacheTTL {
+		_ = os.Remove(cache)
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(cache)
+	if err != nil {
+		return nil, false
+	}
+	lockMemory(data)
+
+	key := cacheKey(info)
+	if !bytes.HasPrefix(data, []byte(key)) {
+		zero(data)
+		_ = os.Remove(cache)
+		return nil, false
+	}
+
+	return data[len(key):], true
+}
+
+// writeCache stores plain as the cached plaintext of path.
+func writeCache(path string, info os.FileInfo, plain []byte) error {
+	dir := cacheDir()
+	err := ensurePrivateDir(dir)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(cacheKey(info))
+	if err == nil {
+		_, err = f.Write(plain)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), cachePath(path))
+}
+
+// clearCache removes any cached plaintext of path.
+func clearCache(path string) {
+	_ = os.Remove(cachePath(path))
+}
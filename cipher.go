@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// compressAlgos are the values accepted by -compress. Secrets are high entropy
+// and compressing them only risks leaking information through the size, so
+// none is the default.
+var compressAlgos = []string{"none", "zip", "zlib"}
+
+// cipher encrypts and decrypts the contents of secrets files.
+type cipher interface {
+	// ext is the file extension used for groups encrypted by the cipher.
+	ext() string
+	encrypt(plaintext []byte) ([]byte, error)
+	decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// streamCipher is implemented by ciphers that can decrypt without buffering
+// the plaintext.
+type streamCipher interface {
+	decryptStream(ciphertext io.Reader, w io.Writer) error
+}
+
+// newCipher returns the backend selected with -backend, configured from the
+// command line. Commands only use the cipher through activeCipher, so a fake
+// can be put in its place.
+func newCipher(name string) (cipher, error) {
+	if !contains(compressAlgos, compress) {
+		return nil, fmt.Errorf("Invalid compression %s, must be one of %s", compress, strings.Join(compressAlgos, ", "))
+	}
+
+	if recipientSelf && name != "gpg" {
+		return nil, fmt.Errorf("-recipient-self requires the gpg backend")
+	}
+
+	if recipientsFile != "" && name != "gpg" && name != "age" {
+		return nil, fmt.Errorf("-recipients-file requires the gpg or age backend")
+	}
+
+	switch name {
+	case "age":
+		return ageCipher{recipients: ageRecipients, identity: ageIdentity, recipientsFile: recipientsFile}, nil
+	case "openpgp":
+		return newOpenPGPCipher(keyring, compress)
+	case "gpg":
+		s2k, err := s2kArgs()
+		if err != nil {
+			return nil, err
+		}
+
+		if gpgRemote != "" && askPassphrase {
+			return nil, fmt.Errorf("-ask-passphrase can't be used with -gpg-remote")
+		}
+
+		return gpgCipher{s2k: s2k, compress: compress, remote: gpgRemote, recipients: gpgRecipients, recipientsFile: recipientsFile, self: recipientSelf}, nil
+	default:
+		return nil, fmt.Errorf("Unknown backend: %s", name)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// clipboardCommands are tried in order to read the clipboard.
+var clipboardCommands = [][]string{
+	{"pbpaste"},
+	{"wl-paste", "--no-newline"},
+	{"xclip", "-selection", "clipboard", "-o"},
+	{"xsel", "--clipboard", "--output"},
+}
+
+// readClipboard returns the contents of the clipboard using the first
+// clipboard tool found on the PATH.
+func readClipboard() ([]byte, error) {
+	for _, c := range clipboardCommands {
+		if _, err := exec.LookPath(c[0]); err != nil {
+			continue
+		}
+
+		stdout, stderr, err := system(c[0], false, c[1:]...)
+		if err != nil {
+			zero(stdout)
+			return nil, fmt.Errorf("%s: %v\n%s", c[0], err, stderr)
+		}
+
+		return stdout, nil
+	}
+
+	return nil, errors.New("no clipboard tool found, install pbpaste, wl-paste, xclip or xsel")
+}
@@ -0,0 +1,31 @@
+package main
+
+import "os"
+
+// Colors are all the same length so that tabwriter, which counts the escape
+// codes as text, still lines up columns when every cell is colored.
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorDim   = "\x1b[2m"
+)
+
+// useColor reports whether human readable output should be colored. Color is
+// only used on a terminal and never when NO_COLOR is set or with -no-color.
+func useColor() bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	return isTerminal(os.Stdout)
+}
+
+// colorize returns s in the given color when color is enabled. Cosmetic
+// output only: decrypted secrets are never passed through it.
+func colorize(color, s string) string {
+	if !useColor() {
+		return s
+	}
+
+	return color + s + colorReset
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// dumpConfig prints the settings in effect and where each came from: the
+// environment, the config file, a command line flag or the default.
+func dumpConfig() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	setting := func(name, value, source string) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, value, source)
+	}
+
+	setting("secrets-dir", secretsDir, "$HOME")
+	if group != "" {
+		setting("secrets-file", secretsFile, "-group")
+	}
+
+	gpgPath, err := exec.LookPath(gpgBinary)
+	if err != nil {
+		gpgPath = "not found"
+	}
+	setting("gpg", gpgPath, "$PATH and -gpg")
+
+	if editor := os.Getenv("EDITOR"); editor != "" {
+		setting("editor", editor, "$EDITOR")
+	} else {
+		setting("editor", "vi", "default")
+	}
+
+	if backend == "age" || backend == "gpg" {
+		name := backend + "-recipients"
+		switch {
+		case backend == "age" && len(ageRecipients) > 0:
+			setting(name, ageRecipients.String(), "-age-recipient")
+		case backend == "gpg" && len(gpgRecipients) > 0:
+			setting(name, gpgRecipients.String(), "-gpg-recipient")
+		case recipientsFile != "":
+			setting(name, recipientsFile, "-recipients-file")
+		default:
+			path, err := findRecipientsFile(recipientsSearchDir())
+			switch {
+			case err != nil:
+				setting(name, err.Error(), recipientsFileName)
+			case path != "":
+				setting(name, path, recipientsFileName)
+			default:
+				setting(name, "none, using a passphrase", "default")
+			}
+		}
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	flag.VisitAll(func(f *flag.Flag) {
+		source := "default"
+		if configFlags[f.Name] {
+			source = "config"
+		} else if set[f.Name] {
+			source = "flag"
+		}
+		setting("-"+f.Name, f.Value.String(), source)
+	})
+
+	w.Flush()
+}
+
+// configPath returns the global config file, which sets defaults for flags.
+func configPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+
+	return filepath.Join(dir, "unseal", "config")
+}
+
+// configFlags holds the flags set by the config file, for -dump-config.
+var configFlags = make(map[string]bool)
+
+// loadConfig sets flags from the config file, one "name = value" per line.
+// Flags given on the command line win over the config file.
+func loadConfig() error {
+	commandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		commandLine[f.Name] = true
+	})
+
+	path := configPath()
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value := line, "true"
+		if j := strings.IndexByte(line, '='); j >= 0 {
+			name, value = strings.TrimSpace(line[:j]), strings.TrimSpace(line[j+1:])
+		}
+		name = strings.TrimLeft(name, "-")
+		if commandLine[name] {
+			continue
+		}
+
+		err = flag.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		configFlags[name] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// descriptionPrefix marks the comment line holding a group's description.
+// Being a comment, it is never injected as a variable.
+const descriptionPrefix = "# unseal-description: "
+
+// describe prints the group's description, or replaces it when -describe is
+// given.
+func describe() {
+	ensureSecrets()
+
+	plain := decryptFile()
+	defer zero(plain)
+
+	if description == "" {
+		fmt.Println(getDescription(plain))
+		return
+	}
+
+	contents := setDescription(plain, description)
+	defer zero(contents)
+
+	vars, err := parseEnvironment(contents)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning, secrets will not be usable: ", err)
+	}
+
+	err = saveSecrets(contents)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	printSaved(len(vars))
+}
+
+// list prints every group along with its description. Groups that fail to
+// decrypt are listed without one. With -0 only the names are printed, NUL
+// terminated for xargs -0, and nothing is decrypted.
+func list() {
+	groups, err := listGroups()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to list secrets groups: ", err)
+		exit(1)
+	}
+
+	if nullSep {
+		for _, name := range groups {
+			fmt.Print(name, "\x00")
+		}
+		return
+	}
+
+	if format == "table" {
+		listTable(groups)
+		return
+	}
+
+	for _, name := range groups {
+		plain, err := decryptPath(groupFile(name))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to decrypt group", name, ": ", err)
+			fmt.Println(name)
+			continue
+		}
+
+		desc := getDescription(plain)
+		zero(plain)
+
+		if desc == "" {
+			fmt.Println(name)
+		} else {
+			fmt.Printf("%s\t%s\n", name, desc)
+		}
+	}
+}
+
+// listTable prints the groups as aligned columns with their variable count,
+// modification time and description.
+func listTable(groups []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	header := []string{"GROUP", "VARS", "MODIFIED", "DESCRIPTION"}
+	for i := range header {
+		header[i] = colorize(colorBold, header[i])
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for _, name := range groups {
+		path := groupFile(name)
+
+		modified := "-"
+		if info, err := os.Stat(path); err == nil {
+			modified = info.ModTime().Format("2006-01-02 15:04")
+		}
+
+		count, desc := "?", ""
+		plain, err := decryptPath(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to decrypt group", name, ": ", err)
+		} else {
+			if vars, err := parseEnvironment(plain); err == nil {
+				count = strconv.Itoa(len(vars))
+			}
+			desc = getDescription(plain)
+			zero(plain)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", colorize(colorReset, name), colorize(colorReset, count),
+			colorize(colorReset, modified), colorize(colorDim, desc))
+	}
+
+	w.Flush()
+}
+
+func getDescription(plain []byte) string {
+	for _, line := range bytes.Split(plain, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if bytes.HasPrefix(line, []byte(descriptionPrefix)) {
+			return string(line[len(descriptionPrefix):])
+		}
+	}
+
+	return ""
+}
+
+// setDescription returns a copy of plain with its description line replaced
+// by desc.
+func setDescription(plain []byte, desc string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(descriptionPrefix)
+	buf.WriteString(strings.ReplaceAll(desc, "\n", " "))
+
+	for _, line := range bytes.Split(plain, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte(descriptionPrefix)) {
+			continue
+		}
+
+		buf.WriteByte('\n')
+		buf.Write(line)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// diff compares the group with the plaintext -diff-plain file, printing the
+// variables the file would add (+), remove (-) or change (~) if imported.
+// Values are only printed with -show-values. It exits with 1 when they
+// differ, like diff(1).
+func diff() {
+	if diffPlain == "" {
+		fmt.Fprintln(os.Stderr, "Diff requires a plaintext file to compare with -diff-plain")
+		exit(1)
+	}
+
+	ensureSecrets()
+
+	contents, err := ioutil.ReadFile(diffPlain)
+	lockMemory(contents)
+	defer zero(contents)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read plaintext file: ", err)
+		exit(1)
+	}
+
+	theirs, err := parseEnvironment(contents)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing plaintext file: ", err)
+		exit(1)
+	}
+
+	plain := decrypt()
+	ours, err := parseEnvironment(plain)
+	zero(plain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing secrets: ", err)
+		exit(1)
+	}
+
+	if showValues {
+		confirmTerminalOutput()
+	}
+
+	changed := false
+	for _, key := range sortedKeys(ours) {
+		val, ok := theirs[key]
+		switch {
+		case !ok:
+			printDiff("-", key, ours[key], "")
+		case val != ours[key]:
+			printDiff("~", key, ours[key], val)
+		default:
+			continue
+		}
+		changed = true
+	}
+
+	for _, key := range sortedKeys(theirs) {
+		if _, ok := ours[key]; !ok {
+			printDiff("+", key, "", theirs[key])
+			changed = true
+		}
+	}
+
+	if changed {
+		exit(1)
+	}
+}
+
+func printDiff(op, key, ours, theirs string) {
+	if !showValues {
+		fmt.Println(op, key)
+		return
+	}
+
+	switch op {
+	case "-":
+		fmt.Printf("%s %s%s%s\n", op, key, envSeparator, ours)
+	case "+":
+		fmt.Printf("%s %s%s%s\n", op, key, envSeparator, theirs)
+	default:
+		fmt.Printf("%s %s%s%s -> %s\n", op, key, envSeparator, ours, theirs)
+	}
+}
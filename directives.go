@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Group variables starting with directivePrefix configure how wrap injects the
+// group rather than being injected themselves.
+const directivePrefix = "UNSEAL_"
+
+const (
+	// groupCmdVar is the program to wrap when none is given on the command
+	// line.
+	groupCmdVar = "UNSEAL_CMD"
+	// groupOnlyVar is a comma separated list of the only variables to inject.
+	groupOnlyVar = "UNSEAL_ONLY"
+	// groupPrefixVar is prepended to the name of every injected variable.
+	groupPrefixVar = "UNSEAL_PREFIX"
+)
+
+const (
+	// exposedGroupVar and exposedFileVar tell wrapped programs where their
+	// secrets came from, with -expose-path.
+	exposedGroupVar = "UNSEAL_GROUP"
+	exposedFileVar  = "UNSEAL_SECRETS_FILE"
+)
+
+type directives struct {
+	cmd    []string
+	only   []string
+	prefix string
+}
+
+// groupEnvironment decrypts the group and returns the variables to inject
+// after applying the group's directives.
+func groupEnvironment() (map[string]string, directives) {
+	vars := layerEnvironment(true)
+	if resolveRefs {
+		resolveReferences(vars)
+	}
+	d := extractDirectives(vars)
+
+	filtered, err := filterVars(vars)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error filtering secrets: ", err)
+		exit(1)
+	}
+
+	injected := d.apply(filtered)
+	if exposePath {
+		exposeGroupPath(injected)
+	}
+
+	return injected, d
+}
+
+// exposeGroupPath adds the group and the absolute path of its secrets file to
+// vars.
+func exposeGroupPath(vars map[string]string) {
+	vars[exposedGroupVar] = group
+	if secretsFile == stdioGroup {
+		return
+	}
+
+	path, err := filepath.Abs(secretsFile)
+	if err != nil {
+		path = secretsFile
+	}
+	vars[exposedFileVar] = path
+}
+
+// extractDirectives removes the directive variables from vars and returns
+// them parsed.
+func extractDirectives(vars map[string]string) directives {
+	var d directives
+
+	for key, val := range vars {
+		if !strings.HasPrefix(key, directivePrefix) {
+			continue
+		}
+		delete(vars, key)
+
+		switch key {
+		case groupCmdVar:
+			d.cmd = strings.Fields(val)
+		case groupOnlyVar:
+			for _, name := range strings.Split(val, ",") {
+				name = strings.TrimSpace(name)
+				if name != "" {
+					d.only = append(d.only, name)
+				}
+			}
+		case groupPrefixVar:
+			d.prefix = val
+		default:
+			if !quiet {
+				fmt.Fprintln(os.Stderr, "Ignoring unknown directive", key)
+			}
+		}
+	}
+
+	return d
+}
+
+func (d directives) apply(vars map[string]string) map[string]string {
+	result := make(map[string]string, len(vars))
+
+	for key, val := range vars {
+		if len(d.only) > 0 && !contains(d.only, key) {
+			continue
+		}
+
+		result[d.prefix+key] = val
+	}
+
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
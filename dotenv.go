@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// parseDotenv splits raw into KEY=value pairs following the rules of the
+// dotenv npm package, so files shared with Node and Python projects parse the
+// same way. Lines that aren't assignments are ignored.
+func parseDotenv(raw []byte) (map[string]string, error) {
+	vars := make(map[string]string)
+	lines := make(keyLines)
+
+	for i, line := range bytes.Split(raw, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if bytes.HasPrefix(line, []byte("export")) && len(line) > 6 && isBlank(line[6]) {
+			line = bytes.TrimLeft(line[6:], " \t")
+		}
+
+		eq := bytes.IndexByte(line, '=')
+		if eq < 0 {
+			continue
+		}
+
+		key := bytes.TrimSpace(line[:eq])
+		if !isDotenvKey(key) {
+			continue
+		}
+
+		if bytes.IndexByte(line, 0) >= 0 {
+			return nil, fmt.Errorf("variable %q contains a NUL byte", key)
+		}
+
+		vars[string(key)] = dotenvValue(bytes.TrimLeft(line[eq+1:], " \t"))
+		lines[string(key)] = append(lines[string(key)], i+1)
+	}
+
+	err := lines.checkDuplicates()
+	if err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+// dotenvValue unquotes a value. Quoted values end at the closing quote, with
+// \n and \r expanded only inside double quotes. Unquoted values end at a #
+// preceded by whitespace.
+func dotenvValue(v []byte) string {
+	if len(v) > 0 && (v[0] == '"' || v[0] == '\'' || v[0] == '`') {
+		if end := closingQuote(v); end > 0 {
+			if v[0] != '"' {
+				return string(v[1:end])
+			}
+
+			newlines := bytes.Replace(v[1:end], []byte(`\n`), []byte("\n"), -1)
+			expanded := bytes.Replace(newlines, []byte(`\r`), []byte("\r"), -1)
+			s := string(expanded)
+			zero(newlines)
+			zero(expanded)
+
+			return s
+		}
+	}
+
+	for i := 1; i < len(v); i++ {
+		if v[i] == '#' && isBlank(v[i-1]) {
+			v = v[:i]
+			break
+		}
+	}
+
+	return string(bytes.TrimSpace(v))
+}
+
+// splitComment splits a dotenv value from its trailing comment, keeping the
+// whitespace before the # with the comment.
+func splitComment(v []byte) ([]byte, []byte) {
+	start := 1
+	if len(v) > 0 && (v[0] == '"' || v[0] == '\'' || v[0] == '`') {
+		if end := closingQuote(v); end > 0 {
+			start = end + 1
+		}
+	}
+
+	for i := start; i < len(v); i++ {
+		if v[i] == '#' && (i == start && start > 1 || isBlank(v[i-1])) {
+			j := i
+			for j > 0 && isBlank(v[j-1]) {
+				j--
+			}
+			return v[:j], v[j:]
+		}
+	}
+
+	return v, nil
+}
+
+// dotenvQuote quotes s so that dotenvValue reads it back. Line breaks can only
+// be escaped inside double quotes.
+func dotenvQuote(s string) (string, error) {
+	if !strings.ContainsAny(s, "\r\n") {
+		for _, q := range []string{"'", `"`, "`"} {
+			if !strings.Contains(s, q) {
+				return q + s + q, nil
+			}
+		}
+	} else if !strings.Contains(s, `"`) && !strings.Contains(s, `\n`) && !strings.Contains(s, `\r`) {
+		s = strings.ReplaceAll(s, "\n", `\n`)
+		s = strings.ReplaceAll(s, "\r", `\r`)
+		return `"` + s + `"`, nil
+	}
+
+	return "", errors.New("value can't be quoted for dotenv")
+}
+
+// closingQuote returns the index of the quote ending the value opened by
+// v[0], skipping escaped quotes, or -1 if it is never closed.
+func closingQuote(v []byte) int {
+	for i := 1; i < len(v); i++ {
+		switch v[i] {
+		case '\\':
+			i++
+		case v[0]:
+			return i
+		}
+	}
+
+	return -1
+}
+
+func isDotenvKey(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '_', c == '.', c == '-':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// dockerEnv formats vars as a docker --env-file, which takes every line
+// literally as KEY=VALUE. Values with line breaks can't be represented, so
+// they are left out with a warning.
+func dockerEnv(vars map[string]string) []byte {
+	var buf bytes.Buffer
+	for _, key := range sortedKeys(vars) {
+		val := vars[key]
+		if strings.ContainsAny(val, "\r\n") {
+			fmt.Fprintln(os.Stderr, "Warning: leaving out", key, "as docker env files can't hold line breaks")
+			continue
+		}
+
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(val)
+		buf.WriteByte('\n')
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// systemdEnv formats vars as a systemd EnvironmentFile. Values that aren't
+// plain words are double quoted, escaping the characters systemd unescapes
+// there. Variables systemd would reject are left out with a warning.
+func systemdEnv(vars map[string]string) []byte {
+	var buf bytes.Buffer
+	for _, key := range sortedKeys(vars) {
+		if !envName.MatchString(key) {
+			fmt.Fprintln(os.Stderr, "Warning: leaving out", key, "as it isn't a valid systemd variable name")
+			continue
+		}
+
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(systemdQuote(vars[key]))
+		buf.WriteByte('\n')
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+func systemdQuote(s string) string {
+	plain := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-./:@%+,=", r))
+	}) < 0
+	if plain {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if strings.ContainsRune(`"\$`+"`", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+func sortedKeys(vars map[string]string) []string {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
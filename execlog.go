@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// runLogged runs the wrapped command, recording it in the -exec-log.
+func runLogged(args []string, vars map[string]string) error {
+	c := wrapCommand(args, vars)
+	var flush func()
+	c.Stdout, c.Stderr, flush = outputWriters(vars)
+
+	start := time.Now()
+	_, _, err := run(c, true)
+	flush()
+	logExec(args, start, err)
+
+	return err
+}
+
+// logExec records a command started at start that finished with err in the
+// -exec-log, when one is given.
+func logExec(args []string, start time.Time, err error) {
+	if execLog == "" {
+		return
+	}
+
+	logErr := writeExecLog(args, start, time.Since(start), err)
+	if logErr != nil {
+		fmt.Fprintln(os.Stderr, "Error writing exec log: ", logErr)
+	}
+}
+
+// writeExecLog appends a line describing a finished command to the exec log,
+// which is stderr when -exec-log is "-".
+func writeExecLog(args []string, start time.Time, duration time.Duration, err error) error {
+	var w io.Writer = os.Stderr
+	if execLog != "-" {
+		f, err := os.OpenFile(execLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, mode)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = fmt.Sprintf("%q", arg)
+	}
+
+	_, err = fmt.Fprintf(w, "exec start=%s duration=%s exit=%s argv=[%s]\n",
+		start.UTC().Format(time.RFC3339), duration.Round(time.Millisecond), exitDescription(err), strings.Join(quoted, " "))
+	return err
+}
+
+// exitDescription describes how a command that returned err finished.
+func exitDescription(err error) string {
+	if err == nil {
+		return "0"
+	}
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return "error"
+	}
+
+	if _, name, ok := exitSignal(err); ok {
+		return "signal:" + name
+	}
+
+	return fmt.Sprint(exitErr.ExitCode())
+}
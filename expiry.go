@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// expiryLayouts are the formats accepted for expiry dates.
+var expiryLayouts = []string{"2006-01-02", time.RFC3339}
+
+// checkExpiry reports the variables whose companion expiry variable, named
+// with -expiry-suffix, is past or within -within of now. It fails if any have
+// expired or can't be read.
+func checkExpiry() {
+	vars := decryptEnvironment()
+
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		if strings.HasSuffix(key, expirySuffix) && key != expirySuffix {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	now := time.Now()
+	failed := false
+	for _, key := range keys {
+		name := strings.TrimSuffix(key, expirySuffix)
+
+		expires, err := parseExpiry(vars[key])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: unable to read the expiry date in %s: %v\n", name, key, err)
+			failed = true
+			continue
+		}
+
+		date := expires.Format("2006-01-02")
+		switch {
+		case !now.Before(expires):
+			fmt.Printf("%s: expired on %s\n", name, date)
+			failed = true
+		case expires.Sub(now) <= expiryWithin:
+			fmt.Printf("%s: expires on %s\n", name, date)
+		}
+	}
+
+	if failed {
+		exit(1)
+	}
+}
+
+func parseExpiry(value string) (time.Time, error) {
+	var err error
+	for _, layout := range expiryLayouts {
+		var t time.Time
+		t, err = time.ParseInLocation(layout, strings.TrimSpace(value), time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, err
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// exportAll decrypts every group into a file in the -o directory. The
+// directory must only be accessible by its owner.
+func exportAll() {
+	if output == "" {
+		fmt.Fprintln(os.Stderr, "Export requires an output directory")
+		exit(1)
+	}
+
+	if format != "env" && format != "json" && format != "docker-env" && format != "systemd" && format != "k8s-secret" {
+		fmt.Fprintln(os.Stderr, "Unknown format", format, "for export")
+		exit(1)
+	}
+
+	err := ensurePrivateDir(output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	groups, err := listGroups()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to list secrets groups: ", err)
+		exit(1)
+	}
+
+	failed := false
+	for _, name := range groups {
+		err := exportGroup(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to export group", name, ": ", err)
+			failed = true
+			continue
+		}
+
+		if !quiet {
+			fmt.Fprintln(os.Stderr, "Exported group", name)
+		}
+	}
+
+	if failed {
+		exit(1)
+	}
+}
+
+func exportGroup(name string) error {
+	plain, err := decryptPath(groupFile(name))
+	if err != nil {
+		return err
+	}
+
+	plain, err = inheritPlaintext(name, plain)
+	if err != nil {
+		return err
+	}
+	defer zero(plain)
+
+	if envPrefix != "" {
+		plain, err = filterLines(plain)
+		if err != nil {
+			return err
+		}
+		defer zero(plain)
+	}
+
+	contents := plain
+	ext := format
+	switch format {
+	case "env":
+		if sorted {
+			contents = sortLines(plain)
+			defer zero(contents)
+		}
+	case "json":
+		vars, err := parseEnvironment(plain)
+		if err != nil {
+			return err
+		}
+
+		contents, err = json.MarshalIndent(vars, "", "  ")
+		if err != nil {
+			return err
+		}
+		defer zero(contents)
+	case "docker-env":
+		vars, err := parseEnvironment(plain)
+		if err != nil {
+			return err
+		}
+
+		contents = dockerEnv(vars)
+		defer zero(contents)
+		ext = "env"
+	case "systemd":
+		vars, err := parseEnvironment(plain)
+		if err != nil {
+			return err
+		}
+
+		contents = systemdEnv(vars)
+		defer zero(contents)
+		ext = "env"
+	case "k8s-secret":
+		vars, err := parseEnvironment(plain)
+		if err != nil {
+			return err
+		}
+
+		contents, err = k8sSecretManifest(name, vars)
+		if err != nil {
+			return err
+		}
+		defer zero(contents)
+		ext = "secret.json"
+	}
+
+	path := filepath.Join(output, filepath.FromSlash(name)+"."+ext)
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	// Replace an earlier export rather than writing through it.
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := writeFile(path, contents)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString("\n")
+	return err
+}
+
+// ensurePrivateDir creates dir if needed and checks that no one but its owner
+// can access it.
+func ensurePrivateDir(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("Refusing to write secrets to %s, it must only be accessible by its owner (chmod 700)", dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// writeFifo creates a named pipe at path and writes contents to the first
+// reader to open it. The pipe is removed once the contents have been consumed
+// or fifoTimeout has passed without a reader.
+func writeFifo(path string, contents []byte) error {
+	err := mkfifo(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	done := make(chan error, 1)
+	go func() {
+		f, err := os.OpenFile(path, os.O_WRONLY, mode)
+		if err != nil {
+			done <- err
+			return
+		}
+
+		_, err = f.Write(contents)
+		if err == nil {
+			_, err = f.WriteString("\n")
+		}
+		f.Close()
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-time.After(fifoTimeout):
+		return errors.New("timed out waiting for a reader")
+	}
+}
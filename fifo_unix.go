@@ -0,0 +1,10 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import "syscall"
+
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, mode)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// charsets are the named character sets for -charset. Any other value is
+// used as the set of characters itself.
+var charsets = map[string]string{
+	"alnum":  "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+	"hex":    "0123456789abcdef",
+	"digits": "0123456789",
+	"url":    "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_",
+}
+
+// gen stores a random value under a variable in the group.
+func gen() {
+	if len(execargs) != 1 || !envName.MatchString(execargs[0]) {
+		fmt.Fprintln(os.Stderr, "Gen requires the name of a single variable")
+		exit(1)
+	}
+	key := execargs[0]
+
+	ensureGroup()
+	if secretsFile == stdioGroup {
+		fmt.Fprintln(os.Stderr, "Gen can't be used with the", stdioGroup, "group")
+		exit(1)
+	}
+
+	value, err := randomValue(genLength, genCharset)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to generate a value: ", err)
+		exit(1)
+	}
+	defer zero(value)
+
+	setVar(key, value)
+
+	if genPrint {
+		confirmTerminalOutput()
+		os.Stdout.Write(value)
+		fmt.Println()
+	}
+}
+
+// setVar stores value under key in the group, creating the group if needed.
+// An existing variable is only replaced with -force.
+func setVar(key string, value []byte) {
+	var plain []byte
+	if fileExists(secretsFile) {
+		plain = decryptFile()
+		defer zero(plain)
+	}
+
+	vars, err := parseEnvironment(plain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing secrets: ", err)
+		exit(1)
+	}
+
+	key, err = lookupKey(vars, key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+	_, exists := vars[key]
+	if exists && !force {
+		fmt.Fprintln(os.Stderr, "Variable", key, "is already set in group", group+", use -force to replace it")
+		exit(1)
+	}
+	if exists {
+		confirmDestructive(fmt.Sprintf("replace %s in group '%s'", key, group))
+	}
+
+	line := append([]byte(key+envSeparator), value...)
+	defer zero(line)
+	updated, _ := mergeLines(plain, line)
+	defer zero(updated)
+
+	err = saveSecrets(updated)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+	if !exists {
+		vars[key] = ""
+	}
+	printSaved(len(vars))
+}
+
+// randomValue returns length characters picked uniformly from charset with
+// crypto/rand.
+func randomValue(length int, charset string) ([]byte, error) {
+	if length < 1 {
+		return nil, fmt.Errorf("-len must be at least 1")
+	}
+
+	if named, ok := charsets[charset]; ok {
+		charset = named
+	}
+	chars := []rune(charset)
+	if len(chars) < 2 {
+		return nil, fmt.Errorf("-charset must have at least 2 characters")
+	}
+	if strings.IndexFunc(charset, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return nil, fmt.Errorf("-charset can't contain whitespace or control characters")
+	}
+
+	max := big.NewInt(int64(len(chars)))
+	value := make([]byte, 0, length*utf8.UTFMax)
+	lockMemory(value[:cap(value)])
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			zero(value)
+			return nil, err
+		}
+		value = append(value, string(chars[n.Int64()])...)
+	}
+
+	return value, nil
+}
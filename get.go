@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// get prints the value of a single variable, quoted for a shell with
+// -shell-escape. With -decode the raw bytes of an encoded value are written
+// as they are, without a trailing newline.
+func get() {
+	if len(execargs) != 1 {
+		fmt.Fprintln(os.Stderr, "Get requires the name of a single variable")
+		exit(1)
+	}
+
+	ensureSecrets()
+	confirmTerminalOutput()
+
+	vars := decryptEnvironment()
+	key, err := lookupKey(vars, execargs[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	val, ok := vars[key]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Variable", execargs[0], "is not set in group", group)
+		exit(1)
+	}
+
+	if decode != "" {
+		raw, err := decodeValue(val, decode)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to decode", execargs[0]+": ", err)
+			exit(1)
+		}
+		os.Stdout.Write(raw)
+		zero(raw)
+		return
+	}
+
+	if shellEscape {
+		val = shellQuote(val)
+	}
+
+	fmt.Println(val)
+}
+
+// lookupKey returns the name key is stored under in vars. With -ignore-case
+// it matches regardless of case, failing when several variables match.
+func lookupKey(vars map[string]string, key string) (string, error) {
+	if !ignoreCase {
+		return key, nil
+	}
+
+	var matches []string
+	for _, name := range sortedKeys(vars) {
+		if strings.EqualFold(name, key) {
+			matches = append(matches, name)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return key, nil
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%s matches several variables in group %s: %s", key, group, strings.Join(matches, ", "))
+	}
+}
+
+// encodeValue encodes raw with -encode so that it can be stored as a value.
+func encodeValue(raw []byte, encoding string) ([]byte, error) {
+	var encoded []byte
+	switch encoding {
+	case "base64":
+		encoded = make([]byte, base64.StdEncoding.EncodedLen(len(raw)))
+		base64.StdEncoding.Encode(encoded, raw)
+	case "hex":
+		encoded = make([]byte, hex.EncodedLen(len(raw)))
+		hex.Encode(encoded, raw)
+	default:
+		return nil, fmt.Errorf("unknown encoding %s, must be base64 or hex", encoding)
+	}
+	lockMemory(encoded)
+
+	return encoded, nil
+}
+
+// decodeValue reverses encodeValue for -decode.
+func decodeValue(val, encoding string) ([]byte, error) {
+	switch encoding {
+	case "base64":
+		return base64.StdEncoding.DecodeString(val)
+	case "hex":
+		return hex.DecodeString(val)
+	default:
+		return nil, fmt.Errorf("unknown encoding %s, must be base64 or hex", encoding)
+	}
+}
+
+// shellQuote wraps s in single quotes so a POSIX shell reads it back as a
+// single word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// gitClean encrypts stdin to stdout, for use as the clean command of a git
+// filter:
+//
+//	git config filter.unseal.clean 'unseal -cmd clean %f'
+//	git config filter.unseal.smudge 'unseal -cmd smudge'
+//
+// Encrypting the same file twice gives different ciphertext, which git would
+// see as a change. Given the path, the staged ciphertext is reused when it
+// still decrypts to the same contents.
+func gitClean() {
+	if len(execargs) > 1 {
+		fmt.Fprintln(os.Stderr, "Clean takes at most the path of the file being filtered")
+		exit(1)
+	}
+
+	plain, err := ioutil.ReadAll(os.Stdin)
+	lockMemory(plain)
+	defer zero(plain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read stdin: ", err)
+		exit(1)
+	}
+
+	if len(execargs) == 1 {
+		staged := stagedCiphertext(execargs[0])
+		if staged != nil && decryptsTo(staged, plain) {
+			os.Stdout.Write(staged)
+			return
+		}
+	}
+
+	ciphertext, err := activeCipher.encrypt(plain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error encrypting: ", err)
+		exit(1)
+	}
+	os.Stdout.Write(ciphertext)
+}
+
+// gitSmudge decrypts stdin to stdout, for use as the smudge command of a git
+// filter.
+func gitSmudge() {
+	ciphertext, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read stdin: ", err)
+		exit(1)
+	}
+
+	if len(ciphertext) == 0 {
+		return
+	}
+
+	plain, err := decryptBlocks(ciphertext)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error decrypting: ", err)
+		exit(1)
+	}
+	os.Stdout.Write(plain)
+	zero(plain)
+}
+
+// stagedCiphertext returns the contents of path in the git index, or nil if it
+// isn't staged.
+func stagedCiphertext(path string) []byte {
+	staged, err := exec.Command("git", "cat-file", "blob", ":"+path).Output()
+	if err != nil || len(staged) == 0 {
+		return nil
+	}
+
+	return staged
+}
+
+func decryptsTo(ciphertext, plain []byte) bool {
+	decrypted, err := decryptBlocks(ciphertext)
+	if err != nil {
+		return false
+	}
+	defer zero(decrypted)
+
+	return bytes.Equal(decrypted, plain)
+}
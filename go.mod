@@ -1,3 +1,8 @@
 module git.cotugno.family/kevin/unseal
 
 go 1.15
+
+require (
+	github.com/ProtonMail/go-crypto v1.0.0
+	golang.org/x/term v0.6.0
+)
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// gpgCipher encrypts groups with gpg, symmetrically unless recipients are
+// given.
+type gpgCipher struct {
+	// s2k are the options tuning how the passphrase is turned into a key.
+	s2k []string
+	// compress is the --compress-algo used when encrypting.
+	compress string
+	// remote is the ssh destination whose gpg decrypts, for keys that live
+	// on another host.
+	remote string
+	// recipients are the keys to encrypt to instead of a passphrase.
+	recipients []string
+	// recipientsFile lists recipients, one per line, used when none are
+	// given on the command line.
+	recipientsFile string
+	// self adds the default secret key to the recipients.
+	self bool
+}
+
+func (gpgCipher) ext() string {
+	return ".gpg"
+}
+
+func (g gpgCipher) encrypt(plaintext []byte) ([]byte, error) {
+	recipients := g.recipients
+	if len(recipients) == 0 {
+		var err error
+		recipients, err = readRecipients(g.recipientsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if g.self {
+		key, err := defaultSecretKey()
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(append([]string{}, recipients...), key)
+	}
+
+	var args []string
+	if len(recipients) > 0 {
+		args = append(args, "--compress-algo", g.compress, "--armor", "-e")
+		for _, r := range recipients {
+			args = append(args, "-r", r)
+		}
+	} else {
+		args = append(args, g.s2k...)
+		args = append(args, "--compress-algo", g.compress)
+		args = append(args, "--armor", "--cipher-algo", "AES256", "-c")
+	}
+
+	stdout, stderr, err := gpg(bytes.NewReader(plaintext), args...)
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, stderr)
+	}
+
+	return stdout, nil
+}
+
+func (g gpgCipher) decrypt(ciphertext []byte) ([]byte, error) {
+	var stdout []byte
+	var stderr string
+	var err error
+
+	if g.remote != "" {
+		c := remoteGpgCommand(g.remote, "-d")
+		c.Stdin = bytes.NewReader(ciphertext)
+		stdout, stderr, err = run(c, false)
+		showGpgDiagnostics(stderr, err)
+	} else {
+		stdout, stderr, err = gpg(bytes.NewReader(ciphertext), "-d")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, stderr)
+	}
+
+	return stdout, nil
+}
+
+func (g gpgCipher) decryptStream(ciphertext io.Reader, w io.Writer) error {
+	if g.remote != "" {
+		c := remoteGpgCommand(g.remote, "-d")
+		c.Stdin = ciphertext
+		c.Stdout = w
+		c.Stderr = os.Stderr
+
+		return c.Run()
+	}
+
+	return gpgStream(ciphertext, w, "-d")
+}
+
+// remoteGpgCommand runs gpg on remote over ssh. The ciphertext and plaintext
+// travel over the ssh connection, and any passphrase is asked for by the
+// remote gpg-agent.
+func remoteGpgCommand(remote string, args ...string) *exec.Cmd {
+	command := "gpg"
+	for _, arg := range gpgArgs(args) {
+		command += " " + shellQuote(arg)
+	}
+
+	return exec.Command("ssh", "-T", "-e", "none", "--", remote, command)
+}
+
+// pgpMessageHeader starts every armored OpenPGP message.
+var pgpMessageHeader = []byte("-----BEGIN PGP MESSAGE-----")
+
+// decryptBlocks decrypts ciphertext with the active cipher. A gpg secrets file
+// holding several armored messages, such as when new secrets are appended
+// instead of re-encrypting the file, has each message decrypted in turn and
+// their plaintexts joined by newlines.
+func decryptBlocks(ciphertext []byte) ([]byte, error) {
+	if secretsExt != ".gpg" || bytes.Count(ciphertext, pgpMessageHeader) < 2 {
+		return activeCipher.decrypt(ciphertext)
+	}
+
+	var plain []byte
+	for _, block := range armoredBlocks(ciphertext) {
+		p, err := activeCipher.decrypt(block)
+		if err != nil {
+			zero(plain)
+			return nil, err
+		}
+
+		joined := make([]byte, 0, len(plain)+len(p)+1)
+		joined = append(joined, plain...)
+		joined = append(joined, p...)
+		if len(p) > 0 && p[len(p)-1] != '\n' {
+			joined = append(joined, '\n')
+		}
+		zero(plain)
+		zero(p)
+		plain = joined
+	}
+
+	return plain, nil
+}
+
+// armoredBlocks splits ciphertext into its armored messages.
+func armoredBlocks(ciphertext []byte) [][]byte {
+	var blocks [][]byte
+	for {
+		start := bytes.Index(ciphertext, pgpMessageHeader)
+		if start < 0 {
+			return blocks
+		}
+
+		ciphertext = ciphertext[start:]
+		next := bytes.Index(ciphertext[len(pgpMessageHeader):], pgpMessageHeader)
+		if next < 0 {
+			return append(blocks, ciphertext)
+		}
+
+		next += len(pgpMessageHeader)
+		blocks = append(blocks, ciphertext[:next])
+		ciphertext = ciphertext[next:]
+	}
+}
+
+// defaultSecretKey returns the fingerprint of the first usable secret key in
+// the keyring, which gpg treats as the default key.
+func defaultSecretKey() (string, error) {
+	c := exec.Command(gpgBinary, gpgArgs([]string{"--list-secret-keys", "--with-colons"})...)
+	c.Env = append(os.Environ(), "LC_ALL=C")
+	stdout, stderr, err := run(c, false)
+	if err != nil {
+		return "", fmt.Errorf("Unable to list secret keys: %v\n%s", err, stderr)
+	}
+
+	usable := false
+	for _, line := range strings.Split(string(stdout), "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "sec":
+			// Skip revoked, expired, invalid and disabled keys.
+			usable = len(fields) > 1 && !strings.ContainsAny(fields[1], "reid")
+		case fields[0] == "fpr" && usable && len(fields) > 9:
+			return fields[9], nil
+		}
+	}
+
+	return "", fmt.Errorf("No usable secret key found for -recipient-self")
+}
+
+func gpg(stdin io.Reader, args ...string) ([]byte, string, error) {
+	c, err := gpgCommand(args)
+	if err != nil {
+		return nil, "", err
+	}
+	defer closeFiles(c.ExtraFiles)
+
+	c.Stdin = stdin
+
+	stdout, stderr, err := run(c, false)
+	showGpgDiagnostics(stderr, err)
+
+	return stdout, stderr, err
+}
+
+// showGpgDiagnostics prints what gpg wrote to stderr with -gpg-verbose. On
+// failure the caller reports it instead.
+func showGpgDiagnostics(stderr string, err error) {
+	if gpgVerbose && err == nil {
+		fmt.Fprint(os.Stderr, stderr)
+	}
+}
+
+// gpgStream runs gpg writing straight to w so its output is never held in
+// memory.
+func gpgStream(stdin io.Reader, w io.Writer, args ...string) error {
+	c, err := gpgCommand(args)
+	if err != nil {
+		return err
+	}
+	defer closeFiles(c.ExtraFiles)
+
+	c.Stdin = stdin
+	c.Stdout = w
+	c.Stderr = os.Stderr
+
+	return c.Run()
+}
+
+// gpgCommand runs gpg in the C locale so its messages are not mangled by
+// localized encodings. GPG_TTY is filled in when missing so pinentry can find
+// the terminal. With -ask-passphrase the passphrase is handed over on a pipe
+// instead of going through pinentry.
+func gpgCommand(args []string) (*exec.Cmd, error) {
+	var extraFiles []*os.File
+	if askPassphrase {
+		r, err := passphrasePipe()
+		if err != nil {
+			return nil, err
+		}
+
+		extraFiles = append(extraFiles, r)
+		args = append([]string{"--batch", "--pinentry-mode", "loopback", "--passphrase-fd", "3"}, args...)
+	}
+
+	c := exec.Command(gpgBinary, gpgArgs(args)...)
+	c.Env = append(os.Environ(), "LC_ALL=C")
+	c.ExtraFiles = extraFiles
+
+	if os.Getenv("GPG_TTY") == "" {
+		tty := terminalName()
+		if tty != "" {
+			c.Env = append(c.Env, "GPG_TTY="+tty)
+		}
+	}
+
+	return c, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// gpgArgs quiets gpg unless -gpg-verbose is given, independently of -quiet.
+func gpgArgs(args []string) []string {
+	if gpgVerbose {
+		return append([]string{"--verbose"}, args...)
+	}
+
+	return append([]string{"--quiet", "--no-verbose"}, args...)
+}
+
+var s2kDigests = []string{"SHA1", "SHA224", "SHA256", "SHA384", "SHA512", "RIPEMD160"}
+
+// s2kArgs returns the gpg options tuning how the passphrase is turned into a
+// key. Unset options are left to gpg's defaults.
+func s2kArgs() ([]string, error) {
+	var args []string
+
+	switch s2kMode {
+	case 0, 1, 3:
+		args = append(args, "--s2k-mode", strconv.Itoa(s2kMode))
+	default:
+		return nil, fmt.Errorf("Invalid s2k mode %d, must be 0, 1 or 3", s2kMode)
+	}
+
+	if s2kCount != 0 {
+		if s2kCount < 1024 || s2kCount > 65011712 {
+			return nil, fmt.Errorf("Invalid s2k count %d, must be between 1024 and 65011712", s2kCount)
+		}
+		args = append(args, "--s2k-count", strconv.Itoa(s2kCount))
+	}
+
+	if s2kDigest != "" {
+		digest := strings.ToUpper(s2kDigest)
+		if !contains(s2kDigests, digest) {
+			return nil, fmt.Errorf("Invalid s2k digest %s, must be one of %s", s2kDigest, strings.Join(s2kDigests, ", "))
+		}
+		args = append(args, "--s2k-digest-algo", digest)
+	}
+
+	return args, nil
+}
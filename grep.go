@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+const mask = "********"
+
+// grep searches the keys of every group for a pattern. Groups that fail to
+// decrypt are reported and skipped.
+func grep() {
+	if len(execargs) != 1 {
+		fmt.Fprintln(os.Stderr, "Grep requires a single pattern to search for")
+		exit(1)
+	}
+
+	pattern, err := regexp.Compile(execargs[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid pattern: ", err)
+		exit(1)
+	}
+
+	groups, err := listGroups()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to list secrets groups: ", err)
+		exit(1)
+	}
+
+	if reveal {
+		confirmTerminalOutput()
+	}
+
+	found := false
+	for _, name := range groups {
+		plain, err := decryptPath(groupFile(name))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to decrypt group", name, ": ", err)
+			continue
+		}
+
+		vars, err := parseEnvironment(plain)
+		zero(plain)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to parse group", name, ": ", err)
+			continue
+		}
+
+		keys := make([]string, 0, len(vars))
+		for key := range vars {
+			if pattern.MatchString(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			val := colorize(colorDim, mask)
+			if reveal {
+				val = vars[key]
+			}
+
+			fmt.Printf("%s: %s%s%s\n", name, key, envSeparator, val)
+			found = true
+		}
+	}
+
+	if !found {
+		exit(1)
+	}
+}
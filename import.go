@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type importFile struct {
+	group string
+	path  string
+}
+
+// importAll encrypts every .env file in a directory as the group named after
+// it. All files are checked before any are encrypted so a bad file does not
+// leave a partial import behind.
+func importAll() {
+	if len(execargs) != 1 {
+		fmt.Fprintln(os.Stderr, "Import requires the directory to import from")
+		exit(1)
+	}
+
+	files, err := findImportFiles(execargs[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to list files to import: ", err)
+		exit(1)
+	}
+
+	failed := false
+	for _, f := range files {
+		err := checkImportFile(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to import", f.path, ": ", err)
+			failed = true
+		}
+	}
+
+	if failed {
+		exit(1)
+	}
+
+	var replaced []string
+	for _, f := range files {
+		if fileExists(groupFile(f.group)) {
+			replaced = append(replaced, f.group)
+		}
+	}
+	if len(replaced) > 0 && !dryRun {
+		confirmDestructive("overwrite groups " + strings.Join(replaced, ", "))
+	}
+
+	for _, f := range files {
+		if dryRun {
+			fmt.Println("Would import", f.path, "as group", f.group)
+			continue
+		}
+
+		err := importGroup(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to import", f.path, ": ", err)
+			exit(1)
+		}
+	}
+}
+
+// importStdin encrypts the group from KEY=value lines, or a JSON object with
+// -format json, read from stdin.
+func importStdin() {
+	ensureGroup()
+
+	if encode != "" {
+		importEncoded()
+		return
+	}
+
+	if secretsFile != stdioGroup && !force && fileExists(secretsFile) {
+		fmt.Fprintf(os.Stderr, "Group %s already exists, use -force to overwrite it\n", group)
+		exit(1)
+	}
+	if secretsFile != stdioGroup && fileExists(secretsFile) {
+		confirmDestructive(fmt.Sprintf("overwrite group '%s'", group))
+	}
+
+	input, err := ioutil.ReadAll(os.Stdin)
+	lockMemory(input)
+	defer zero(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read stdin: ", err)
+		exit(1)
+	}
+
+	contents := bytes.TrimSpace(input)
+	switch format {
+	case "env":
+	case "json":
+		contents, err = jsonToEnv(contents)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to import JSON: ", err)
+			exit(1)
+		}
+		defer zero(contents)
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown format", format, "for import")
+		exit(1)
+	}
+
+	vars, err := parseEnvironment(contents)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to import: ", err)
+		exit(1)
+	}
+
+	err = saveSecrets(contents)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	printSaved(len(vars))
+}
+
+// importEncoded stores stdin, which may be binary, as the value of a single
+// variable encoded with -encode.
+func importEncoded() {
+	if len(execargs) != 1 || !envName.MatchString(execargs[0]) {
+		fmt.Fprintln(os.Stderr, "Import with -encode requires the name of a single variable")
+		exit(1)
+	}
+
+	if secretsFile == stdioGroup {
+		fmt.Fprintln(os.Stderr, "Import with -encode can't be used with the", stdioGroup, "group")
+		exit(1)
+	}
+
+	input, err := ioutil.ReadAll(os.Stdin)
+	lockMemory(input)
+	defer zero(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read stdin: ", err)
+		exit(1)
+	}
+
+	value, err := encodeValue(input, encode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to import: ", err)
+		exit(1)
+	}
+	defer zero(value)
+
+	setVar(execargs[0], value)
+}
+
+// jsonToEnv converts a JSON object of strings to the lines of a secrets file.
+func jsonToEnv(input []byte) ([]byte, error) {
+	var vars map[string]string
+	err := json.Unmarshal(input, &vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatEnv(vars)
+}
+
+// formatEnv formats vars as sorted lines of each key and value joined by
+// -env-separator. Values are written as is, so a value with a line break can
+// only be stored with -dotenv-compat, which double quotes it.
+func formatEnv(vars map[string]string) ([]byte, error) {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		if !envName.MatchString(key) {
+			return nil, fmt.Errorf("%q is not a valid variable name", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		val := vars[key]
+		if strings.IndexByte(val, 0) >= 0 {
+			return nil, fmt.Errorf("variable %q contains a NUL byte", key)
+		}
+
+		if strings.ContainsAny(val, "\r\n") && !dotenvCompat {
+			return nil, fmt.Errorf("the value of %s contains a line break, use -dotenv-compat to store it quoted", key)
+		}
+
+		if dotenvCompat && strings.ContainsAny(val, "\r\n#\"'` \t") {
+			quoted, err := dotenvQuote(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			val = quoted
+		}
+
+		buf.WriteString(key)
+		buf.WriteString(envSeparator)
+		buf.WriteString(val)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+func findImportFiles(dir string) ([]importFile, error) {
+	var files []importFile
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || filepath.Ext(path) != ".env" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, importFile{
+			group: filepath.ToSlash(strings.TrimSuffix(rel, ".env")),
+			path:  path,
+		})
+		return nil
+	})
+
+	return files, err
+}
+
+func checkImportFile(f importFile) error {
+	err := validateGroup(f.group)
+	if err != nil {
+		return err
+	}
+
+	if !force && fileExists(groupFile(f.group)) {
+		return fmt.Errorf("group %s already exists, use -force to overwrite it", f.group)
+	}
+
+	contents, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	defer zero(contents)
+
+	_, err = parseEnvironment(contents)
+	return err
+}
+
+func importGroup(f importFile) error {
+	contents, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	defer zero(contents)
+
+	vars, err := parseEnvironment(contents)
+	if err != nil {
+		return err
+	}
+
+	group = f.group
+	secretsFile = groupFile(f.group)
+
+	err = saveSecrets(bytes.TrimSpace(contents))
+	if err != nil {
+		return err
+	}
+
+	printSaved(len(vars))
+	return nil
+}
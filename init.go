@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// starterConfig is written by init. Every setting is commented out so that
+// the defaults stay in effect until the user opts in.
+const starterConfig = `# unseal config. Each line sets the default for a flag as "name = value".
+# Flags given on the command line take precedence.
+
+# backend = gpg
+# gpg = gpg
+# cache = 0s
+# no-color = true
+`
+
+// initialize sets up the secrets directory and the config file, then runs
+// the self test. Anything that already exists is left alone, so it is safe
+// to run again.
+func initialize() {
+	existed := fileExists(secretsDir)
+	err := ensurePrivateDir(secretsDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to set up the secrets directory: ", err)
+		exit(1)
+	}
+	if existed {
+		fmt.Println("Secrets directory", secretsDir, "already exists")
+	} else {
+		fmt.Println("Created secrets directory", secretsDir)
+	}
+
+	path := configPath()
+	if fileExists(path) {
+		fmt.Println("Config", path, "already exists")
+	} else {
+		err = writeStarterConfig(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to write config: ", err)
+			exit(1)
+		}
+		fmt.Println("Wrote config", path)
+	}
+
+	selftest()
+
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Println("  unseal -cmd edit -group <name>      create a group of secrets")
+	fmt.Println("  unseal -group <name> -- <command>   run a command with them")
+}
+
+func writeStarterConfig(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, []byte(starterConfig), mode)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// secretName matches a DNS subdomain, the form of a Secret's name.
+var secretName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+
+type k8sMetadata struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type k8sSecret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   k8sMetadata       `json:"metadata"`
+	Type       string            `json:"type"`
+	Data       map[string]string `json:"data"`
+}
+
+// k8sSecretManifest returns a v1 Secret holding vars, as JSON that kubectl
+// accepts. Characters Kubernetes doesn't allow in keys are replaced with _.
+// The Secret is named with -k8s-name, or after the group when unset.
+func k8sSecretManifest(groupName string, vars map[string]string) ([]byte, error) {
+	name := k8sName
+	if name == "" {
+		name = k8sSecretName(groupName)
+	}
+	if !secretName.MatchString(name) {
+		return nil, fmt.Errorf("%q is not a valid Secret name, set one with -k8s-name", name)
+	}
+
+	data := make(map[string]string)
+	from := make(map[string]string)
+	for _, key := range sortedKeys(vars) {
+		k := sanitizeSecretKey(key)
+		if other, ok := from[k]; ok {
+			return nil, fmt.Errorf("variables %s and %s are both stored as Secret key %s", other, key, k)
+		}
+		from[k] = key
+		data[k] = base64.StdEncoding.EncodeToString([]byte(vars[key]))
+	}
+
+	return json.MarshalIndent(k8sSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   k8sMetadata{Name: name, Namespace: k8sNamespace},
+		Type:       "Opaque",
+		Data:       data,
+	}, "", "  ")
+}
+
+func sanitizeSecretKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.', r == '_':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// k8sSecretName turns a group name such as clients/acme_db into a valid
+// Secret name such as clients-acme-db.
+func k8sSecretName(groupName string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, groupName)
+
+	return strings.Trim(name, "-.")
+}
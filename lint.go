@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var sensitiveKey = regexp.MustCompile(`(?i)(pass(word|wd)?|secret|token|api_?key)`)
+
+// placeholders are values commonly left behind in place of a real secret.
+var placeholders = [][]byte{
+	[]byte("changeme"),
+	[]byte("password"),
+	[]byte("placeholder"),
+}
+
+// lint prints advisories about likely mistakes in the group, one per line
+// with its line number. Values are never printed. With -strict any advisory
+// is a failure.
+func lint() {
+	plain := decrypt()
+	advisories := lintEnvironment(plain)
+	zero(plain)
+
+	for _, advisory := range advisories {
+		fmt.Printf("%s:%s\n", group, advisory)
+	}
+
+	if strict && len(advisories) > 0 {
+		exit(1)
+	}
+}
+
+func lintEnvironment(plain []byte) []string {
+	var advisories []string
+	report := func(line int, format string, args ...interface{}) {
+		advisories = append(advisories, fmt.Sprintf("%d: ", line)+fmt.Sprintf(format, args...))
+	}
+
+	seen := make(map[string]int)
+	for i, line := range bytes.Split(plain, []byte("\n")) {
+		n := i + 1
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(bytes.TrimSpace(line)) == 0 || isComment(line) {
+			continue
+		}
+
+		eq := bytes.Index(line, []byte(envSeparator))
+		if eq < 0 {
+			report(n, "not a KEY=value assignment, the line is ignored")
+			continue
+		}
+
+		key := string(line[:eq])
+		val := line[eq+len(envSeparator):]
+		if dotenvCompat {
+			val, _ = splitComment(val)
+		}
+
+		if !envName.MatchString(key) {
+			report(n, "%q is not a valid variable name", key)
+		}
+
+		if first, ok := seen[key]; ok {
+			report(n, "%s is already defined on line %d, the last value wins", key, first)
+		} else {
+			seen[key] = n
+		}
+
+		if len(val) > 1 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			if !dotenvCompat {
+				report(n, "the value of %s is quoted, the quotes are part of the value", key)
+			}
+		} else if len(bytes.TrimSpace(val)) != len(val) {
+			report(n, "the value of %s has leading or trailing whitespace", key)
+		} else if bytes.IndexAny(val, " \t") >= 0 {
+			report(n, "the value of %s contains unquoted spaces", key)
+		}
+
+		if sensitiveKey.MatchString(key) && weakSecret(val) {
+			report(n, "%s looks like a placeholder or weak secret", key)
+		}
+	}
+
+	return advisories
+}
+
+// weakSecret reports whether val is empty, short or a common placeholder.
+func weakSecret(val []byte) bool {
+	val = bytes.Trim(val, `"' `)
+	if len(val) < 8 {
+		return true
+	}
+
+	for _, p := range placeholders {
+		if bytes.EqualFold(val, p) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// merge folds the variables of the group into the -merge-into group. Where
+// both define a variable the group's value wins, and the conflict is
+// reported. With -prune the group is deleted once merged.
+func merge() {
+	if mergeInto == "" {
+		fmt.Fprintln(os.Stderr, "Merge requires a group to merge into with -merge-into")
+		exit(1)
+	}
+
+	if mergeInto == group {
+		fmt.Fprintln(os.Stderr, "Cannot merge group", group, "into itself")
+		exit(1)
+	}
+
+	err := validateGroup(mergeInto)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid group name: ", err)
+		exit(1)
+	}
+
+	targetFile := groupFile(mergeInto)
+	err = checkSymlink(targetFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	if prune {
+		confirmDestructive(fmt.Sprintf("delete group '%s' once merged", group))
+	}
+
+	source := decrypt()
+	defer zero(source)
+
+	var target []byte
+	if fileExists(targetFile) {
+		target, err = decryptPath(targetFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		defer zero(target)
+	}
+
+	merged, conflicts := mergeLines(target, source)
+	defer zero(merged)
+
+	if len(conflicts) > 0 && !quiet {
+		fmt.Fprintln(os.Stderr, "Warning: overriding variables in", mergeInto+":", strings.Join(conflicts, ", "))
+	}
+
+	vars, err := parseEnvironment(merged)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning, secrets will not be usable: ", err)
+	}
+
+	sourceGroup, sourceFile := group, secretsFile
+	group = mergeInto
+	secretsFile = targetFile
+
+	err = saveSecrets(merged)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+	printSaved(len(vars))
+
+	if prune {
+		pruneGroup(sourceGroup, sourceFile)
+	}
+}
+
+// pruneGroup deletes the merged group's file, backing it up first with
+// -backup, and drops its name from the -obfuscate-names index.
+func pruneGroup(name, path string) {
+	if backup {
+		err := backupSecrets(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to back up merged group: ", err)
+			exit(1)
+		}
+	}
+
+	err := os.Remove(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to remove merged group: ", err)
+		exit(1)
+	}
+	clearCache(path)
+
+	if obfuscateNames && path == groupFile(name) {
+		err = removeFromIndex(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to update the group index: ", err)
+			exit(1)
+		}
+	}
+}
+
+// keptComment returns the trailing comment of the old value when the new
+// value has none.
+func keptComment(oldVal, newVal []byte) []byte {
+	sep := len(envSeparator)
+	if len(oldVal) < sep || len(newVal) < sep {
+		return nil
+	}
+
+	if _, c := splitComment(newVal[sep:]); c != nil {
+		return nil
+	}
+	_, c := splitComment(bytes.TrimSuffix(oldVal[sep:], []byte("\r")))
+
+	return c
+}
+
+// mergeLines returns target with the variables of source set in it. Lines of
+// target defining a variable from source are replaced in place and the rest
+// are appended. With -dotenv-compat a replaced line keeps its trailing comment
+// unless the new one has its own. It also returns the variables whose values
+// differed.
+func mergeLines(target, source []byte) ([]byte, []string) {
+	var order []string
+	lines := make(map[string][]byte)
+	for _, line := range bytes.Split(source, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		i := bytes.Index(line, []byte(envSeparator))
+		if i < 0 || isComment(line) {
+			continue
+		}
+
+		key := string(line[:i])
+		if _, ok := lines[key]; !ok {
+			order = append(order, key)
+		}
+		lines[key] = line
+	}
+
+	var buf bytes.Buffer
+	var conflicts []string
+	written := make(map[string]bool)
+	for _, line := range bytes.Split(target, []byte("\n")) {
+		i := bytes.Index(line, []byte(envSeparator))
+		if i < 0 || isComment(line) {
+			buf.Write(line)
+			buf.WriteByte('\n')
+			continue
+		}
+
+		key := string(line[:i])
+		replacement, ok := lines[key]
+		if !ok {
+			buf.Write(line)
+			buf.WriteByte('\n')
+			continue
+		}
+
+		if written[key] {
+			continue
+		}
+		written[key] = true
+
+		if !bytes.Equal(bytes.TrimSuffix(line, []byte("\r")), replacement) {
+			conflicts = append(conflicts, key)
+		}
+		buf.Write(replacement)
+		if dotenvCompat {
+			buf.Write(keptComment(line[i:], replacement[i:]))
+		}
+		buf.WriteByte('\n')
+	}
+
+	for _, key := range order {
+		if !written[key] {
+			buf.Write(lines[key])
+			buf.WriteByte('\n')
+		}
+	}
+	sort.Strings(conflicts)
+
+	return bytes.TrimSpace(buf.Bytes()), conflicts
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+var mlockWarned bool
+
+// lockMemory keeps b out of swap when -mlock is set. Failures are reported
+// once and otherwise ignored since locking usually needs raised limits.
+func lockMemory(b []byte) {
+	if !mlock || len(b) == 0 {
+		return
+	}
+
+	err := mlockBytes(b)
+	if err != nil && !mlockWarned {
+		mlockWarned = true
+		fmt.Fprintln(os.Stderr, "Unable to lock secrets in memory, continuing without: ", err)
+	}
+}
+
+func unlockMemory(b []byte) {
+	if !mlock || len(b) == 0 {
+		return
+	}
+
+	_ = munlockBytes(b)
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+import "errors"
+
+func mlockBytes(b []byte) error {
+	return errors.New("memory locking is not supported on this platform")
+}
+
+func munlockBytes(b []byte) error {
+	return nil
+}
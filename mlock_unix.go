@@ -0,0 +1,14 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package main
+
+import "syscall"
+
+func mlockBytes(b []byte) error {
+	return syscall.Mlock(b)
+}
+
+func munlockBytes(b []byte) error {
+	return syscall.Munlock(b)
+}
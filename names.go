@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// indexName is the file, without extension, holding the encrypted list of
+// group names when -obfuscate-names is used.
+const indexName = ".index"
+
+// hashName returns the file name a group is stored under with
+// -obfuscate-names, so the secrets directory doesn't reveal group names.
+func hashName(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+func indexFile() string {
+	return filepath.Join(secretsDir, indexName+secretsExt)
+}
+
+// readIndex returns the group names recorded in the index, one per line.
+func readIndex() ([]string, error) {
+	if !fileExists(indexFile()) {
+		return nil, nil
+	}
+
+	plain, err := decryptPath(indexFile())
+	if err != nil {
+		return nil, err
+	}
+	defer zero(plain)
+
+	var names []string
+	for _, line := range bytes.Split(plain, []byte("\n")) {
+		if len(line) > 0 {
+			names = append(names, string(line))
+		}
+	}
+
+	return names, nil
+}
+
+// addToIndex records name in the index if it isn't there already.
+func addToIndex(name string) error {
+	names, err := readIndex()
+	if err != nil {
+		return err
+	}
+
+	if contains(names, name) {
+		return nil
+	}
+
+	return writeIndex(append(names, name))
+}
+
+// removeFromIndex drops name from the index, so a deleted group's name isn't
+// kept around.
+func removeFromIndex(name string) error {
+	names, err := readIndex()
+	if err != nil {
+		return err
+	}
+
+	kept := names[:0]
+	for _, n := range names {
+		if n != name {
+			kept = append(kept, n)
+		}
+	}
+	if len(kept) == len(names) {
+		return nil
+	}
+
+	return writeIndex(kept)
+}
+
+// writeIndex replaces the index with the sorted names.
+func writeIndex(names []string) error {
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, n := range names {
+		fmt.Fprintln(&buf, n)
+	}
+
+	ciphertext, err := activeCipher.encrypt(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return writeCiphertext(indexFile(), ciphertext)
+}
+
+// indexedGroups returns the groups in the index whose files still exist.
+func indexedGroups() ([]string, error) {
+	names, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	for _, name := range names {
+		path := groupFile(name)
+		if fileExists(path) && checkSymlink(path) == nil {
+			groups = append(groups, name)
+		}
+	}
+
+	return groups, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// openpgpCipher encrypts and decrypts groups in process, without needing gpg
+// installed. Groups are encrypted symmetrically and stay compatible with the
+// gpg backend. Messages encrypted to a public key can be decrypted when the
+// secret key is in the keyring.
+type openpgpCipher struct {
+	keyring     openpgp.EntityList
+	compression packet.CompressionAlgo
+}
+
+var openpgpCompression = map[string]packet.CompressionAlgo{
+	"none": packet.CompressionNone,
+	"zip":  packet.CompressionZIP,
+	"zlib": packet.CompressionZLIB,
+}
+
+func newOpenPGPCipher(path string, compress string) (cipher, error) {
+	c := openpgpCipher{compression: openpgpCompression[compress]}
+	if path == "" {
+		return c, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open keyring: %v", err)
+	}
+	defer f.Close()
+
+	var keys openpgp.EntityList
+	if isArmored(f) {
+		keys, err = openpgp.ReadArmoredKeyRing(f)
+	} else {
+		keys, err = openpgp.ReadKeyRing(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read keyring: %v", err)
+	}
+
+	c.keyring = keys
+
+	return c, nil
+}
+
+func (openpgpCipher) ext() string {
+	return ".gpg"
+}
+
+func (o openpgpCipher) encrypt(plaintext []byte) ([]byte, error) {
+	if passphrase == nil {
+		err := newPassphrase()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &packet.Config{DefaultCipher: packet.CipherAES256, DefaultCompressionAlgo: o.compression}
+	pt, err := openpgp.SymmetricallyEncrypt(w, passphrase, nil, config)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = pt.Write(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pt.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+
+	return buf.Bytes(), nil
+}
+
+func (o openpgpCipher) decrypt(ciphertext []byte) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(ciphertext)
+	if isArmored(bytes.NewReader(ciphertext)) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		r = block.Body
+	}
+
+	md, err := openpgp.ReadMessage(r, o.keyring, o.prompt(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body := md.UnverifiedBody
+	if maxSize > 0 {
+		body = io.LimitReader(body, maxSize+1)
+	}
+
+	plain, err := ioutil.ReadAll(body)
+	if err != nil {
+		zero(plain)
+		return nil, err
+	}
+
+	if maxSize > 0 && int64(len(plain)) > maxSize {
+		zero(plain)
+		return nil, fmt.Errorf("output exceeds maximum size of %d bytes", maxSize)
+	}
+
+	return plain, nil
+}
+
+// prompt supplies the passphrase, either for a symmetrically encrypted
+// message or to unlock secret keys. ReadMessage keeps asking until the
+// passphrase works, so a second request means it was wrong.
+func (o openpgpCipher) prompt() openpgp.PromptFunction {
+	asked := false
+
+	return func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if asked {
+			return nil, errors.New("incorrect passphrase")
+		}
+		asked = true
+
+		p, err := cachedPassphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range keys {
+			if k.PrivateKey != nil && k.PrivateKey.Encrypted {
+				_ = k.PrivateKey.Decrypt(p)
+			}
+		}
+
+		if symmetric {
+			return p, nil
+		}
+
+		return nil, nil
+	}
+}
+
+// isArmored peeks at r to see if it holds ASCII armored data. r must be
+// seekable so it can be rewound afterwards.
+func isArmored(r io.ReadSeeker) bool {
+	buf := make([]byte, 64)
+	n, _ := io.ReadFull(r, buf)
+	_, _ = r.Seek(0, io.SeekStart)
+
+	return bytes.Contains(buf[:n], []byte("-----BEGIN PGP"))
+}
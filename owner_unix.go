@@ -0,0 +1,29 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// checkOwnedFile checks that path belongs to the current user and that no one
+// else can write to it.
+func checkOwnedFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return errors.New("it is not owned by you")
+	}
+
+	if info.Mode().Perm()&0022 != 0 {
+		return errors.New("it is writable by others")
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// passphrase is read once and reused for every encryption and decryption.
+var passphrase []byte
+
+// cachedPassphrase returns the passphrase, prompting for it the first time.
+func cachedPassphrase() ([]byte, error) {
+	if passphrase == nil {
+		p, err := readPassphrase("Passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+
+		passphrase = p
+		lockMemory(passphrase)
+	}
+
+	return passphrase, nil
+}
+
+// passphrasePipe returns the read end of a pipe holding the passphrase,
+// prompting for it first if needed. The passphrase never appears in argv.
+func passphrasePipe() (*os.File, error) {
+	passphrase, err := cachedPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	_, err = w.Write(passphrase)
+	if err == nil {
+		_, err = w.Write([]byte("\n"))
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// newPassphrase prompts twice for the passphrase of a new group so a typo
+// cannot lock the group away. The confirmed passphrase is used for every
+// following gpg invocation.
+func newPassphrase() error {
+	p, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	confirm, err := readPassphrase("Repeat passphrase: ")
+	defer zero(confirm)
+	if err != nil {
+		zero(p)
+		return err
+	}
+
+	if len(p) == 0 {
+		return errors.New("passphrase is empty")
+	}
+
+	if !bytes.Equal(p, confirm) {
+		zero(p)
+		return errors.New("passphrases do not match")
+	}
+
+	passphrase = p
+	lockMemory(passphrase)
+	askPassphrase = true
+
+	return nil
+}
+
+// readPassphrase prompts on stderr and reads a line from the terminal without
+// echoing it.
+func readPassphrase(prompt string) ([]byte, error) {
+	tty, err := openTerminal()
+	if err != nil {
+		return nil, errors.New("reading a passphrase requires a terminal")
+	}
+	if tty != os.Stdin {
+		defer tty.Close()
+	}
+
+	fd := int(tty.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("reading a passphrase requires a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+
+	return p, err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// reference matches a value of the form @group:KEY, which with
+// -resolve-references takes the value of KEY from another group. A value of
+// the form @@group:KEY is then kept as the literal @group:KEY.
+var reference = regexp.MustCompile(`^@([A-Za-z0-9_./-]+):([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// resolver resolves references, decrypting each referenced group once.
+type resolver struct {
+	groups map[string]map[string]string
+}
+
+// resolveReferences replaces every reference in vars with the value it points
+// to. Referenced values may be references themselves.
+func resolveReferences(vars map[string]string) {
+	r := resolver{groups: map[string]map[string]string{group: vars}}
+
+	for key, val := range vars {
+		resolved, err := r.resolve(val, []string{group + ":" + key})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to resolve %s: %v\n", key, err)
+			exit(1)
+		}
+		vars[key] = resolved
+	}
+}
+
+// resolve returns the value val refers to. seen holds the references followed
+// so far to detect cycles.
+func (r resolver) resolve(val string, seen []string) (string, error) {
+	if strings.HasPrefix(val, "@@") && reference.MatchString(val[1:]) {
+		return val[1:], nil
+	}
+
+	m := reference.FindStringSubmatch(val)
+	if m == nil {
+		return val, nil
+	}
+
+	name, key := m[1], m[2]
+	ref := name + ":" + key
+	if contains(seen, ref) {
+		return "", fmt.Errorf("reference cycle %s -> %s", strings.Join(seen, " -> "), ref)
+	}
+
+	vars, ok := r.groups[name]
+	if !ok {
+		var err error
+		vars, err = loadVars(name)
+		if err != nil {
+			return "", err
+		}
+		r.groups[name] = vars
+	}
+
+	target, ok := vars[key]
+	if !ok {
+		return "", fmt.Errorf("%s is not set in group %s", key, name)
+	}
+
+	return r.resolve(target, append(seen, ref))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+func render() {
+	if templateFile == "" {
+		fmt.Fprintln(os.Stderr, "Render requires a template file")
+		exit(1)
+	}
+
+	ensureSecrets()
+
+	tmpl, err := template.New(filepath.Base(templateFile)).ParseFiles(templateFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing template: ", err)
+		exit(1)
+	}
+
+	if strict {
+		tmpl.Option("missingkey=error")
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, decryptEnvironment())
+	defer zero(buf.Bytes())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error rendering template: ", err)
+		exit(1)
+	}
+
+	err = writeOutput(buf.Bytes())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing output: ", err)
+		exit(1)
+	}
+}
+
+// subst replaces $VAR and ${VAR} references in the template file with
+// secrets, similar to envsubst.
+func subst() {
+	if templateFile == "" {
+		fmt.Fprintln(os.Stderr, "Subst requires a template file")
+		exit(1)
+	}
+
+	ensureSecrets()
+
+	contents, err := ioutil.ReadFile(templateFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading template: ", err)
+		exit(1)
+	}
+
+	vars := decryptEnvironment()
+
+	var missing []string
+	result := []byte(os.Expand(string(contents), func(key string) string {
+		val, ok := vars[key]
+		if !ok {
+			missing = append(missing, key)
+		}
+		return val
+	}))
+	defer zero(result)
+
+	if strict && len(missing) > 0 {
+		fmt.Fprintln(os.Stderr, "Template references missing variables: ", strings.Join(missing, ", "))
+		exit(1)
+	}
+
+	err = writeOutput(result)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing output: ", err)
+		exit(1)
+	}
+}
+
+// writeOutput writes contents to the file named by -o, or stdout when none
+// was given. Output files are created with the same restricted mode as
+// secrets.
+func writeOutput(contents []byte) error {
+	if output == "" {
+		_, err := os.Stdout.Write(contents)
+		return err
+	}
+
+	return ioutil.WriteFile(output, contents, mode)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const backupSuffix = ".bak."
+
+// backupSecrets copies the secrets file at path to a timestamped backup next
+// to it.
+func backupSecrets(path string) error {
+	ciphertext, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := writeFile(path+backupSuffix+time.Now().UTC().Format("20060102T150405.000Z"), ciphertext)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// backups returns the timestamps of the backups of path, newest first.
+func backups(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + backupSuffix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	stamps := make([]string, len(matches))
+	for i, m := range matches {
+		stamps[i] = strings.TrimPrefix(m, path+backupSuffix)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(stamps)))
+
+	return stamps, nil
+}
+
+// restore lists the group's backups, or replaces the group with the backup
+// given as an argument once it is known to decrypt. The replaced file is
+// itself kept as a backup.
+func restore() {
+	ensureGroup()
+
+	stamps, err := backups(secretsFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to list backups: ", err)
+		exit(1)
+	}
+
+	if len(execargs) == 0 {
+		for _, stamp := range stamps {
+			fmt.Println(stamp)
+		}
+		return
+	}
+
+	stamp := execargs[0]
+	if !contains(stamps, stamp) {
+		fmt.Fprintln(os.Stderr, "Group", group, "has no backup", stamp)
+		exit(1)
+	}
+
+	path := secretsFile + backupSuffix + stamp
+	plain, err := decryptPath(path)
+	zero(plain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Backup", stamp, "does not decrypt, not restoring it: ", err)
+		exit(1)
+	}
+
+	confirmDestructive(fmt.Sprintf("replace group '%s' with backup %s", group, stamp))
+
+	if fileExists(secretsFile) {
+		err = backupSecrets(secretsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to back up secrets file: ", err)
+			exit(1)
+		}
+	}
+
+	ciphertext, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = writeCiphertext(secretsFile, ciphertext)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to restore backup: ", err)
+		exit(1)
+	}
+
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Restored group '%s' from %s\n", group, stamp)
+	}
+}
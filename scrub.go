@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// scrubMask replaces secret values in scrubbed output.
+const scrubMask = "***"
+
+// minScrubLength is the shortest value that is masked. Shorter values, like
+// ports and flags, would mask unrelated output all over.
+const minScrubLength = 4
+
+// scrubWriter masks secret values in everything written through it. Output
+// that might be the start of a secret is held back until the rest arrives,
+// so a secret split across writes is still masked.
+type scrubWriter struct {
+	w        io.Writer
+	secrets  [][]byte
+	replacer *strings.Replacer
+	pending  []byte
+}
+
+func newScrubWriter(w io.Writer, vars map[string]string) *scrubWriter {
+	var secrets []string
+	for _, val := range vars {
+		if len(val) >= minScrubLength {
+			secrets = append(secrets, val)
+		}
+	}
+
+	// Longer secrets go first so that a secret containing another is masked
+	// as a whole.
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+	s := &scrubWriter{w: w}
+	var pairs []string
+	for _, secret := range secrets {
+		s.secrets = append(s.secrets, []byte(secret))
+		pairs = append(pairs, secret, scrubMask)
+	}
+	s.replacer = strings.NewReplacer(pairs...)
+
+	return s
+}
+
+func (s *scrubWriter) Write(p []byte) (int, error) {
+	s.pending = append(s.pending, p...)
+
+	cut := len(s.pending) - s.partialSecret(s.pending)
+	cut = s.secretBefore(cut)
+
+	_, err := s.w.Write([]byte(s.replacer.Replace(string(s.pending[:cut]))))
+	s.pending = append(s.pending[:0], s.pending[cut:]...)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// partialSecret returns the length of the longest end of b that a secret
+// starts with.
+func (s *scrubWriter) partialSecret(b []byte) int {
+	keep := 0
+	for _, secret := range s.secrets {
+		n := len(secret) - 1
+		if n > len(b) {
+			n = len(b)
+		}
+
+		for ; n > keep; n-- {
+			if bytes.HasPrefix(secret, b[len(b)-n:]) {
+				keep = n
+				break
+			}
+		}
+	}
+
+	return keep
+}
+
+// secretBefore moves cut back to the start of any whole secret in the pending
+// output that it would split, so the secret is masked as a whole later.
+func (s *scrubWriter) secretBefore(cut int) int {
+	for moved := true; moved; {
+		moved = false
+		for _, secret := range s.secrets {
+			for start := cut - len(secret) + 1; start < cut; start++ {
+				if start >= 0 && bytes.HasPrefix(s.pending[start:], secret) {
+					cut = start
+					moved = true
+					break
+				}
+			}
+		}
+	}
+
+	return cut
+}
+
+// Flush writes out any output held back, once the program has exited.
+func (s *scrubWriter) Flush() error {
+	_, err := s.w.Write([]byte(s.replacer.Replace(string(s.pending))))
+	s.pending = s.pending[:0]
+
+	return err
+}
+
+// outputWriters returns where a wrapped program's stdout and stderr go, masked
+// with -scrub-output. The returned function writes out what is held back.
+func outputWriters(vars map[string]string) (io.Writer, io.Writer, func()) {
+	if !scrubOutput {
+		return os.Stdout, os.Stderr, func() {}
+	}
+
+	stdout := newScrubWriter(os.Stdout, vars)
+	stderr := newScrubWriter(os.Stderr, vars)
+
+	return stdout, stderr, func() {
+		_ = stdout.Flush()
+		_ = stderr.Flush()
+	}
+}
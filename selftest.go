@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// selftest encrypts and decrypts a throwaway group to check that gpg is set
+// up correctly before real secrets depend on it.
+func selftest() {
+	content := []byte("UNSEAL_SELFTEST=" + randChars())
+	secretsFile = filepath.Join(os.TempDir(), "unseal-selftest."+randChars()+secretsExt)
+
+	err := saveSecrets(content)
+	if err != nil {
+		selftestFailed("encrypting", err)
+	}
+
+	plain, err := decryptPath(secretsFile)
+	if err != nil {
+		selftestFailed("decrypting", err)
+	}
+
+	if !bytes.Equal(plain, content) {
+		selftestFailed("verifying", errors.New("decrypted content does not match the original"))
+	}
+
+	removeSelftestFile()
+	fmt.Println("Self test passed")
+}
+
+func selftestFailed(step string, err error) {
+	removeSelftestFile()
+	fmt.Fprintln(os.Stderr, "Self test failed while", step, ": ", err)
+	exit(1)
+}
+
+func removeSelftestFile() {
+	err := os.Remove(secretsFile)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, "Error cleaning up self test file: ", err)
+	}
+}
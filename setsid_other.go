@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func newSession(c *exec.Cmd) error {
+	return errors.New("starting a new session is not supported on this platform")
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// show prints the group's variables with their values masked, except for the
+// -reveal-key variables, so the shape of a group can be shared safely.
+func show() {
+	ensureSecrets()
+
+	vars := decryptEnvironment()
+
+	for _, key := range revealKeys {
+		if _, ok := vars[key]; !ok {
+			fmt.Fprintln(os.Stderr, "Variable", key, "is not set in group", group)
+			exit(1)
+		}
+	}
+
+	if len(revealKeys) > 0 {
+		confirmTerminalOutput()
+	}
+
+	for _, key := range sortedKeys(vars) {
+		val := colorize(colorDim, mask)
+		if contains(revealKeys, key) {
+			val = vars[key]
+		}
+
+		fmt.Printf("%s%s%s\n", key, envSeparator, val)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync/atomic"
+)
+
+// editorRunning is set while the editor has the terminal.
+var editorRunning int32
+
+// exitIfSignaled exits with 128 plus the signal number, as shells do, when err
+// is from a program that was killed by a signal.
+func exitIfSignaled(err error) {
+	if sig, _, ok := exitSignal(err); ok {
+		exit(128 + sig)
+	}
+}
+
+// exitCode returns the status a program that returned err exited with, or 1
+// when it couldn't be run at all.
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+
+	return 1
+}
+
+// trapSignals runs cleanup and exits when the process is asked to terminate.
+// Interrupts that arrive while the editor is running are left for the editor
+// to handle. The returned function stops trapping.
+func trapSignals(cleanup func()) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, terminateSignals...)
+
+	go func() {
+		for sig := range sigs {
+			if sig == os.Interrupt && atomic.LoadInt32(&editorRunning) == 1 {
+				continue
+			}
+
+			cleanup()
+			exit(1)
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}
+}
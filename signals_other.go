@@ -0,0 +1,18 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminateSignals are the signals that ask unseal to terminate. Not every
+// platform has SIGHUP.
+var terminateSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// exitSignal reports no signal where programs aren't killed by signals.
+func exitSignal(err error) (int, string, bool) {
+	return 0, "", false
+}
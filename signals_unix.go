@@ -0,0 +1,29 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// terminateSignals are the signals that ask unseal to terminate.
+var terminateSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+
+// exitSignal returns the number and name of the signal that killed the program
+// that returned err, if one did.
+func exitSignal(err error) (int, string, bool) {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, "", false
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, "", false
+	}
+
+	return int(status.Signal()), status.Signal().String(), true
+}
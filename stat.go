@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// stat prints facts about the group, or every group when none is given,
+// without revealing its contents. With -secret-count the group is decrypted to
+// count its variables and measure its plaintext.
+func stat() {
+	groups := []string{group}
+	if group == "" {
+		var err error
+		groups, err = listGroups()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to list secrets groups: ", err)
+			exit(1)
+		}
+	} else {
+		ensureSecrets()
+	}
+
+	failed := false
+	for _, name := range groups {
+		path := groupFile(name)
+		if name == stdioGroup {
+			path = stdioGroup
+		}
+
+		err := statGroup(name, path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to stat group", name, ": ", err)
+			failed = true
+		}
+	}
+
+	if failed {
+		exit(1)
+	}
+}
+
+func statGroup(name, path string) error {
+	line := name
+	if path != stdioGroup {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		line += fmt.Sprintf("\tsize=%d\tmodified=%s", info.Size(), info.ModTime().UTC().Format(time.RFC3339))
+	}
+
+	if secretCount {
+		plain, err := decryptPath(path)
+		if err != nil {
+			return err
+		}
+		defer zero(plain)
+
+		vars, err := parseEnvironment(plain)
+		if err != nil {
+			return err
+		}
+
+		line += fmt.Sprintf("\tvars=%d\tplaintext=%d", len(vars), len(plain))
+	}
+
+	fmt.Println(line)
+	return nil
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -10,78 +13,468 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var help bool
 var cmd string
 var group string
 var execargs []string
+var watch bool
+var fifo string
+var fifoTimeout time.Duration
+var maxSize int64
+var raw bool
+var mlock bool
+var templateFile string
+var output string
+var strict bool
+var quiet bool
+var editorArgs string
+var safeVim bool
+var reveal bool
+var noClobber bool
+var requireVars bool
+var minVars int
+var description string
+var inherit string
+var chdir string
+var fileVars stringList
+var yes bool
+var askPassphrase bool
+var confirmPassphrase bool
+var s2kMode int
+var s2kCount int
+var s2kDigest string
+var allowSymlink bool
+var format string
+var force bool
+var nullSep bool
+var envPrefix string
+var stripPrefix bool
+var cacheTTL time.Duration
+var noOverride bool
+var dotenvCompat bool
+var compress string
+var shellEscape bool
+var auditLog string
+var noColor bool
+var requireTTY bool
+var keepGoing bool
+var obfuscateNames bool
+var fromClipboard bool
+var mergeInto string
+var prune bool
+var backup bool
+var cleanEnv bool
+var envPassthrough stringList
+var expirySuffix string
+var expiryWithin time.Duration
+var mergeOrder string
+var mergeSources []string
+var setsid bool
+var recipientsFile string
+var dumpConfigFlag bool
+var gpgBinary string
+var tmpSuffix string
+var onMissingGroup string
+var requireNonEmpty bool
+var requireNonEmptyKeys string
+var secretCount bool
+var profile string
+var execLog string
+var gpgRemote string
+var k8sName string
+var k8sNamespace string
+var gpgRecipients stringList
+var recipientSelf bool
+var preExec string
+var postExec string
+var scrubOutput bool
+var genLength int
+var genCharset string
+var genPrint bool
+var encode string
+var decode string
+var exposePath bool
+var confirmPolicy string
+var diffPlain string
+var showValues bool
+var gpgVerbose bool
+var envSeparator string
+var ignoreCase bool
+var revealKeys stringList
+var sorted bool
+var dryRun bool
+var resolveRefs bool
 
+var backend string
+var ageRecipients stringList
+var ageIdentity string
+var keyring string
+
+var activeCipher cipher
+var secretsExt string
+var secretsDir string
 var secretsFile string
 
 const mode = 0600
 
+// stdioGroup is the group name that reads ciphertext from stdin and writes it
+// to stdout instead of using a file in the secrets directory.
+const stdioGroup = "-"
+
 func init() {
 	flag.BoolVar(&help, "help", false, "Show this usage message")
-	flag.StringVar(&cmd, "cmd", "wrap", "Command to run\nValid commands:\n\tdecrypt\n\tedit\n\twrap\n")
-	flag.StringVar(&group, "group", "", "Secrets group to execute on")
+	flag.StringVar(&cmd, "cmd", "wrap", "Command to run\nValid commands:\n\tcheck-expiry\n\tclean\n\tdecrypt\n\tdescribe\n\tdiff\n\tedit\n\texport-all\n\tgen\n\tget\n\tgrep\n\timport\n\timport-all\n\tinit\n\tlint\n\tlist\n\tmerge\n\tpath\n\trender\n\trestore\n\tselftest\n\tshow\n\tsmudge\n\tstat\n\tsubst\n\ttouch\n\twrap\n")
+	flag.StringVar(&group, "group", "", "Secrets group to execute on. Commands that take no other argument, such as decrypt and edit, also accept it as their argument")
+	flag.StringVar(&gpgBinary, "gpg", "gpg", "gpg binary used by the gpg backend")
+	flag.StringVar(&backend, "backend", "gpg", "Encryption backend, gpg, openpgp or age")
+	flag.Var(&ageRecipients, "age-recipient", "age recipient to encrypt to instead of a passphrase. May be repeated")
+	flag.StringVar(&ageIdentity, "age-identity", "", "age identity file to decrypt with instead of a passphrase")
+	flag.StringVar(&keyring, "keyring", "", "OpenPGP secret keyring for decrypting public key messages with the openpgp backend")
+	flag.StringVar(&inherit, "inherit", "", "Base group whose variables are loaded first and overridden by the group, for the commands that read variables, decrypt and export-all")
+	flag.BoolVar(&allowSymlink, "allow-symlink", false, "Allow secrets files that are symlinks")
+	flag.BoolVar(&quiet, "quiet", false, "Suppress informational messages")
+	flag.StringVar(&envPrefix, "env-prefix", "", "Only use variables whose names start with this prefix")
+	flag.BoolVar(&stripPrefix, "strip-prefix", false, "Remove the -env-prefix from variable names (requires -env-prefix)")
+	flag.DurationVar(&cacheTTL, "cache", 0, "Reuse decrypted secrets for this long, keeping the plaintext in a private tmpfs file (e.g. 5m)")
+	flag.BoolVar(&noOverride, "no-override", false, "Don't inject variables that are already set in the environment")
+	flag.BoolVar(&dotenvCompat, "dotenv-compat", false, "Parse secrets with the quoting and comment rules of the dotenv npm package")
+	flag.StringVar(&compress, "compress", "none", "Compression applied before encrypting with gpg or openpgp, none, zip or zlib")
+	flag.BoolVar(&shellEscape, "shell-escape", false, "Quote the value printed by get for use in a shell command line")
+	flag.StringVar(&auditLog, "audit-log", "", "Append a record of each command run, never including secrets, to this file")
+	flag.BoolVar(&noColor, "no-color", false, "Don't color output, also disabled by setting NO_COLOR")
+	flag.BoolVar(&requireTTY, "require-tty", false, "Make edit fail instead of starting an editor when not run from a terminal")
+	flag.BoolVar(&keepGoing, "keep-going", false, "Run every command given to wrap, separated by --, even after one fails")
+	flag.BoolVar(&obfuscateNames, "obfuscate-names", false, "Store groups under a hash of their name, with the names kept in an encrypted index")
+	flag.BoolVar(&fromClipboard, "from-clipboard", false, "Make edit encrypt the clipboard as the group's contents instead of starting an editor")
+	flag.StringVar(&mergeInto, "merge-into", "", "Group the merge command folds the group's variables into, the group's values win")
+	flag.BoolVar(&prune, "prune", false, "Delete the group after merging it")
+	flag.BoolVar(&backup, "backup", false, "Keep a timestamped .bak copy of a group's file before replacing it")
+	flag.BoolVar(&cleanEnv, "clean-env", false, "Run the wrapped program with only the group's variables and -env-passthrough")
+	flag.Var(&envPassthrough, "env-passthrough", "Comma separated variables copied into the -clean-env environment. May be repeated")
+	flag.StringVar(&expirySuffix, "expiry-suffix", "_EXPIRES", "Suffix of the variables holding expiry dates for check-expiry")
+	flag.DurationVar(&expiryWithin, "within", 30*24*time.Hour, "Report secrets expiring within this long with check-expiry")
+	flag.StringVar(&mergeOrder, "merge-order", "host,inherit,group", "Comma separated order the host environment, -inherit group and group are layered in when reading variables, later ones win. Sources left out are not used, so leaving out host implies -clean-env")
+	flag.BoolVar(&setsid, "setsid", false, "Run the wrapped program in a new session, detached from the terminal's signals (Unix only)")
+	flag.StringVar(&recipientsFile, "recipients-file", "", "File listing gpg or age recipients, one per line, for the backend in use (default the nearest "+recipientsFileName+" above the secrets file)")
+	flag.BoolVar(&dumpConfigFlag, "dump-config", false, "Print the settings in effect and where they come from, then exit")
+	flag.StringVar(&tmpSuffix, "tmp-suffix", ".env", "Suffix of the temporary file edited, so the editor can detect its type")
+	flag.StringVar(&onMissingGroup, "on-missing-group", "error", "What wrap does when the group doesn't exist, error or skip to run without secrets")
+	flag.BoolVar(&requireNonEmpty, "require-nonempty", false, "Fail when any variable has an empty value")
+	flag.StringVar(&requireNonEmptyKeys, "require-nonempty-keys", "", "Comma separated variables that must be set and not empty")
+	flag.BoolVar(&secretCount, "secret-count", false, "Make stat decrypt to report the number of variables and plaintext size, never their contents")
+	flag.StringVar(&profile, "profile", "", "Environment variant of the group, so -group app -profile prod uses app-prod")
+	flag.StringVar(&execLog, "exec-log", "", "Record each wrapped command's arguments, exit status and duration to this file, or - for stderr")
+	flag.StringVar(&gpgRemote, "gpg-remote", "", "Decrypt by running gpg on this ssh destination (user@host) instead of locally")
+	flag.StringVar(&k8sName, "k8s-name", "", "Name of the Secret for -format k8s-secret, defaults to the group name")
+	flag.StringVar(&k8sNamespace, "k8s-namespace", "", "Namespace of the Secret for -format k8s-secret")
+	flag.Var(&gpgRecipients, "gpg-recipient", "gpg key to encrypt to instead of a passphrase. May be repeated")
+	flag.BoolVar(&recipientSelf, "recipient-self", false, "Also encrypt to your default gpg secret key, so you can decrypt what you encrypt for others")
+	flag.StringVar(&preExec, "pre-exec", "", "Command to run before the wrapped programs, with the same environment. Arguments are split on spaces")
+	flag.StringVar(&postExec, "post-exec", "", "Command to run after the wrapped programs, even if they fail")
+	flag.BoolVar(&scrubOutput, "scrub-output", false, fmt.Sprintf("Mask secret values of at least %d bytes in the output of wrapped programs", minScrubLength))
+	flag.IntVar(&genLength, "len", 32, "Length of the value created by gen")
+	flag.StringVar(&genCharset, "charset", "alnum", "Characters of the value created by gen: alnum, hex, digits, url or the characters themselves")
+	flag.BoolVar(&genPrint, "print", false, "Print the value created by gen")
+	flag.StringVar(&encode, "encode", "", "Import stdin as the value of a single variable, encoded with base64 or hex")
+	flag.StringVar(&decode, "decode", "", "Decode the value printed by get from base64 or hex")
+	flag.BoolVar(&exposePath, "expose-path", false, "Set "+exposedGroupVar+" and "+exposedFileVar+" for wrapped programs to the group and its secrets file")
+	flag.StringVar(&confirmPolicy, "confirm-destructive", "prompt", "Whether operations that destroy data ask first (prompt), always go ahead (yes) or always refuse (no)")
+	flag.StringVar(&diffPlain, "diff-plain", "", "Plaintext env file for diff to compare the group with")
+	flag.BoolVar(&showValues, "show-values", false, "Print the values that differ with diff")
+	flag.BoolVar(&gpgVerbose, "gpg-verbose", false, "Show gpg's diagnostics, whatever -quiet is set to")
+	flag.StringVar(&envSeparator, "env-separator", "=", "Separator between the name and value of variables in secrets files, such as \": \" for colon delimited files")
+	flag.BoolVar(&ignoreCase, "ignore-case", false, "Match the variable given to get, gen and import -encode regardless of case")
+	flag.Var(&revealKeys, "reveal-key", "Variable whose value show prints unmasked. May be repeated")
+	flag.BoolVar(&sorted, "sorted", false, "Order the variables printed by decrypt and written by export-all by name. Other formats are always sorted")
+	flag.BoolVar(&resolveRefs, "resolve-references", false, "Replace values of the form @group:KEY with KEY's value in that group when wrapping. Write @@group:KEY for a literal @group:KEY")
+	flag.BoolVar(&requireVars, "require-vars", false, "Fail instead of warning when a group has no variables to inject")
+	flag.IntVar(&minVars, "min-vars", 0, "Fail when a group has fewer than this many variables to inject")
+	flag.StringVar(&chdir, "chdir", "", "Directory to run the wrapped program in")
+	flag.Var(&fileVars, "file-var", "Write variable KEY to a file for the wrapped program, given as KEY=PATH or KEY\nfor a temporary file. The path is exposed as KEY_FILE. May be repeated")
+	flag.BoolVar(&watch, "watch", false, "Restart the wrapped program when the secrets file changes")
+	flag.BoolVar(&askPassphrase, "ask-passphrase", false, "Prompt for the passphrase instead of using gpg's pinentry")
+	flag.BoolVar(&confirmPassphrase, "confirm-passphrase", false, "Prompt twice for the passphrase of a new group")
+	flag.IntVar(&s2kMode, "s2k-mode", 3, "gpg passphrase mangling mode when encrypting, 0 plain, 1 salted or 3 iterated and salted")
+	flag.IntVar(&s2kCount, "s2k-count", 0, "gpg passphrase iteration count when encrypting, 1024 to 65011712 (default gpg's calibrated count)")
+	flag.StringVar(&s2kDigest, "s2k-digest", "", "gpg passphrase digest algorithm when encrypting, such as SHA512 (default gpg's)")
+	flag.BoolVar(&yes, "yes", false, "Print decrypted secrets to a terminal and confirm destructive operations without asking")
+	flag.StringVar(&fifo, "fifo", "", "Write decrypted secrets to a named pipe at this path instead of stdout")
+	flag.BoolVar(&raw, "raw", false, "Stream the decrypted file to stdout as is, without buffering")
+	flag.BoolVar(&nullSep, "0", false, "Separate list output with NUL characters instead of newlines")
+	flag.BoolVar(&reveal, "reveal", false, "Show secret values in grep output")
+	flag.StringVar(&description, "describe", "", "Description to store with the describe command")
+	flag.BoolVar(&force, "force", false, "Overwrite existing groups with import-all and existing variables with gen")
+	flag.BoolVar(&dryRun, "dry-run", false, "Show what import-all would do without encrypting anything")
+	flag.BoolVar(&noClobber, "no-clobber", false, "Refuse to edit a group that already exists")
+	flag.StringVar(&editorArgs, "editor-args", "", "Extra arguments passed to the editor before the secrets file")
+	flag.BoolVar(&safeVim, "safe-vim", true, "Disable swap, undo and viminfo files when the editor is vim")
+	flag.StringVar(&templateFile, "template", "", "Template file to render with the render or subst command")
+	flag.StringVar(&output, "o", "", "Write output to this file instead of stdout, or the directory for export-all")
+	flag.StringVar(&format, "format", "env", "Output format for decrypt and export-all and input format for import, env, json, docker-env or systemd, k8s-secret for a Kubernetes Secret, or table for list")
+	flag.BoolVar(&strict, "strict", false, "Fail when a group defines a variable more than once, a template references a missing variable or lint reports an advisory")
+	flag.BoolVar(&mlock, "mlock", false, "Lock decrypted secrets in memory so they are never swapped to disk")
+	flag.Int64Var(&maxSize, "max-size", 10<<20, "Maximum size in bytes of decrypted secrets, 0 for no limit")
+	flag.DurationVar(&fifoTimeout, "fifo-timeout", 30*time.Second, "How long to wait for a reader to open the named pipe")
+}
+
+// parseFlags parses the command line and sets up the state every command
+// depends on. It is kept out of init so tests can set that state themselves.
+func parseFlags() {
 	flag.Parse()
 
 	execargs = flag.Args()
-	secretsFile = fmt.Sprintf("%s/.secrets/%s.gpg", os.Getenv("HOME"), group)
+	err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config: ", err)
+		exit(1)
+	}
+
+	err = groupFromArgs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	if inherit != "" && !configFlags["inherit"] && !contains(inheritCmds, cmd) {
+		fmt.Fprintln(os.Stderr, "-inherit can't be used with", cmd)
+		exit(1)
+	}
+
+	sources, err := parseMergeOrder(mergeOrder)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -merge-order: ", err)
+		exit(1)
+	}
+	mergeSources = sources
+	if !contains(sources, "host") {
+		cleanEnv = true
+	}
+
+	if strings.ContainsAny(tmpSuffix, `/\`) {
+		fmt.Fprintln(os.Stderr, "-tmp-suffix must not contain a path separator")
+		exit(1)
+	}
+
+	if confirmPolicy != "prompt" && confirmPolicy != "yes" && confirmPolicy != "no" {
+		fmt.Fprintln(os.Stderr, "-confirm-destructive must be prompt, yes or no")
+		exit(1)
+	}
+
+	if onMissingGroup != "error" && onMissingGroup != "skip" {
+		fmt.Fprintln(os.Stderr, "-on-missing-group must be error or skip")
+		exit(1)
+	}
+
+	if envSeparator == "" {
+		fmt.Fprintln(os.Stderr, "-env-separator must not be empty")
+		exit(1)
+	}
+
+	if dotenvCompat && envSeparator != "=" {
+		fmt.Fprintln(os.Stderr, "-env-separator can't be used with -dotenv-compat")
+		exit(1)
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		if (f.Name == "pre-exec" || f.Name == "post-exec") && strings.TrimSpace(f.Value.String()) == "" {
+			fmt.Fprintf(os.Stderr, "-%s must not be empty\n", f.Name)
+			exit(1)
+		}
+	})
+
+	if stripPrefix && envPrefix == "" {
+		fmt.Fprintln(os.Stderr, "-strip-prefix requires -env-prefix")
+		exit(1)
+	}
+
+	c, err := newCipher(backend)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+	activeCipher = c
+	secretsExt = c.ext()
+
+	secretsDir = filepath.Join(os.Getenv("HOME"), ".secrets")
+	if profile != "" && group != "" && group != stdioGroup {
+		group += "-" + profile
+	}
+	secretsFile = groupFile(group)
+	if group == stdioGroup {
+		secretsFile = stdioGroup
+	}
+
+	if auditLog != "" {
+		err = openAuditLog(auditLog)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to open audit log: ", err)
+			exit(1)
+		}
+	}
+}
+
+func system(command string, pipe bool, args ...string) ([]byte, string, error) {
+	return run(exec.Command(command, args...), pipe)
 }
 
-func system(command string, pipe bool, args ...string) (string, string, error) {
+func run(c *exec.Cmd, pipe bool) ([]byte, string, error) {
 	var err error
 	var stdout, stderr []byte
 	var stdoutPipe, stderrPipe io.ReadCloser
 
-	c := exec.Command(command, args...)
-
-	c.Stdin = os.Stdin
+	if c.Stdin == nil {
+		c.Stdin = os.Stdin
+	}
 	if pipe {
-		c.Stdout = os.Stdout
-		c.Stderr = os.Stderr
+		if c.Stdout == nil {
+			c.Stdout = os.Stdout
+		}
+		if c.Stderr == nil {
+			c.Stderr = os.Stderr
+		}
 	} else {
 		stdoutPipe, err = c.StdoutPipe()
 		if err != nil {
-			return "", "", err
+			return nil, "", err
 		}
 		defer stdoutPipe.Close()
 
 		stderrPipe, err = c.StderrPipe()
 		if err != nil {
-			return "", "", err
+			return nil, "", err
 		}
 		defer stderrPipe.Close()
 	}
 
 	err = c.Start()
 	if err != nil {
-		return "", "", err
+		return nil, "", err
 	}
 
 	if !pipe {
-		stdout, err = ioutil.ReadAll(stdoutPipe)
+		var stdoutReader io.Reader = stdoutPipe
+		if maxSize > 0 {
+			stdoutReader = io.LimitReader(stdoutPipe, maxSize+1)
+		}
+
+		stdout, err = ioutil.ReadAll(stdoutReader)
 		if err != nil {
-			return "", "", err
+			zero(stdout)
+			return nil, "", err
+		}
+
+		if maxSize > 0 && int64(len(stdout)) > maxSize {
+			zero(stdout)
+			_ = c.Process.Kill()
+			_ = c.Wait()
+			return nil, "", fmt.Errorf("output exceeds maximum size of %d bytes", maxSize)
 		}
 
 		stderr, err = ioutil.ReadAll(stderrPipe)
 		if err != nil {
-			return "", "", err
+			zero(stdout)
+			return nil, "", err
 		}
 
 	}
 
 	err = c.Wait()
 
-	return string(stdout), string(stderr), err
+	return stdout, string(stderr), err
+}
+
+// terminalName returns the terminal connected to stdin, or an empty string
+// when stdin is not a terminal.
+func terminalName() string {
+	if !isTerminal(os.Stdin) {
+		return ""
+	}
+
+	c := exec.Command("tty")
+	c.Stdin = os.Stdin
+
+	out, err := c.Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmTerminalOutput asks before secrets are printed to a terminal where
+// they would end up in the scrollback. Pipes and files are never prompted for.
+func confirmTerminalOutput() {
+	if yes || !isTerminal(os.Stdout) {
+		return
+	}
+
+	if !isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "Refusing to print secrets to a terminal, use -yes to override")
+		exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Print secrets for group '%s' to the terminal? [y/N] ", group)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return
+	}
+
+	exit(1)
 }
 
-func gpg(args ...string) (string, string, error) {
-	return system("gpg", false, append([]string{"--quiet", "--no-verbose"}, args...)...)
+// confirmDestructive checks that action, which destroys data, may go ahead
+// following -confirm-destructive. With prompt the user is asked on the
+// terminal unless -yes is given.
+func confirmDestructive(action string) {
+	switch confirmPolicy {
+	case "yes":
+		return
+	case "no":
+		fmt.Fprintln(os.Stderr, "Refusing to", action, "with -confirm-destructive no")
+		exit(1)
+	}
+
+	if yes {
+		return
+	}
+
+	tty, err := openTerminal()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Refusing to", action, "without confirmation, use -yes to override")
+		exit(1)
+	}
+	if tty != os.Stdin {
+		defer tty.Close()
+	}
+
+	fmt.Fprintf(os.Stderr, "Really %s? [y/N] ", action)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return
+	}
+
+	exit(1)
 }
 
 func fileExists(path string) bool {
@@ -94,205 +487,1505 @@ func fileExists(path string) bool {
 }
 
 func main() {
+	parseFlags()
+
 	if help {
 		printHelp()
 		return
 	}
 
+	if dumpConfigFlag {
+		dumpConfig()
+		return
+	}
+
 	switch cmd {
+	case "check-expiry":
+		checkExpiry()
+	case "clean":
+		gitClean()
 	case "decrypt":
-		fmt.Println(decrypt())
+		decryptCommand()
+	case "describe":
+		describe()
+	case "diff":
+		diff()
 	case "edit":
 		edit()
+	case "export-all":
+		exportAll()
+	case "gen":
+		gen()
+	case "get":
+		get()
+	case "grep":
+		grep()
+	case "import":
+		importStdin()
+	case "init":
+		initialize()
+	case "import-all":
+		importAll()
+	case "lint":
+		lint()
+	case "list":
+		list()
+	case "merge":
+		merge()
+	case "path":
+		printPath()
+	case "render":
+		render()
+	case "restore":
+		restore()
+	case "selftest":
+		selftest()
+	case "show":
+		show()
+	case "smudge":
+		gitSmudge()
+	case "stat":
+		stat()
+	case "subst":
+		subst()
+	case "touch":
+		touch()
 	case "wrap":
 		wrap()
 	default:
 		fmt.Println("Unknown command: ", cmd)
 		printHelp()
 	}
+
+	writeAudit(0)
 }
 
 func ensureSecrets() {
 	ensureGroup()
 
-	if !fileExists(secretsFile) {
+	if secretsFile != stdioGroup && !fileExists(secretsFile) {
 		fmt.Println("Secrets file ", group, "does not exist. Create one with the edit command")
-		os.Exit(1)
+		exit(1)
+	}
+}
+
+// printPath prints the secrets file the group resolves to, whether or not it
+// exists.
+func printPath() {
+	ensureGroup()
+	fmt.Println(secretsFile)
+}
+
+// positionalGroupCmds are the commands that also take the group as their
+// argument, as in -cmd decrypt app.
+var positionalGroupCmds = []string{"check-expiry", "decrypt", "describe", "diff", "edit", "lint", "merge", "path", "render", "show", "stat", "subst", "touch"}
+
+// inheritCmds are the commands that layer the group over the -inherit group.
+var inheritCmds = []string{"check-expiry", "decrypt", "export-all", "get", "render", "show", "subst", "wrap"}
+
+// groupFromArgs takes the group from the argument of the commands that accept
+// one. A group set in the config file is overridden, but one given with
+// -group must agree.
+func groupFromArgs() error {
+	if !contains(positionalGroupCmds, cmd) || len(execargs) == 0 {
+		return nil
+	}
+
+	if len(execargs) > 1 {
+		return fmt.Errorf("%s takes a single group, got %s", cmd, strings.Join(execargs, " "))
+	}
+
+	arg := execargs[0]
+	if group != "" && group != arg && !configFlags["group"] {
+		return fmt.Errorf("Group given as both %s with -group and %s", group, arg)
 	}
+
+	group = arg
+	execargs = nil
+
+	return nil
 }
 
 func ensureGroup() {
 	if group == "" {
 		fmt.Println("Group name is required")
-		os.Exit(1)
+		exit(1)
 	}
-}
 
-func decryptFile() string {
-	if !fileExists(secretsFile) {
-		return ""
+	if group == stdioGroup {
+		return
 	}
 
-	stdout, stderr, err := gpg("-d", secretsFile)
+	err := validateGroup(group)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err, "\n", stderr)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "Invalid group name: ", err)
+		exit(1)
 	}
 
-	return strings.TrimSpace(stdout)
+	err = checkSymlink(secretsFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
 }
 
-func decrypt() string {
-	ensureSecrets()
+// checkSymlink refuses secrets files that are symlinks, which could redirect
+// an edit to overwrite an unexpected file, unless -allow-symlink is set.
+func checkSymlink(path string) error {
+	if allowSymlink {
+		return nil
+	}
 
-	return decryptFile()
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+
+	target, err := os.Readlink(path)
+	if err != nil {
+		target = "an unknown location"
+	}
+
+	return fmt.Errorf("Secrets file %s is a symlink to %s, use -allow-symlink to follow it", path, target)
 }
 
-func edit() {
-	var contents string
-	ensureGroup()
+// validateGroup rejects group names that would resolve to a file outside of
+// the secrets directory.
+func validateGroup(name string) error {
+	if strings.IndexByte(name, 0) >= 0 {
+		return fmt.Errorf("%q contains a NUL byte", name)
+	}
+
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return fmt.Errorf("%s must be a relative path", name)
+	}
+
+	if contains(strings.Split(filepath.ToSlash(name), "/"), "..") {
+		return fmt.Errorf("%s must not contain '..'", name)
+	}
+
+	dir := filepath.Clean(secretsDir) + string(filepath.Separator)
+	if !strings.HasPrefix(filepath.Clean(groupFile(name)), dir) {
+		return fmt.Errorf("%s is outside of the secrets directory", name)
+	}
+
+	return nil
+}
 
-	if fileExists(secretsFile) {
-		contents = decryptFile()
+// decryptFile returns the trimmed plaintext of the secrets file. Callers
+// should zero the returned slice once they are done with it.
+func decryptFile() []byte {
+	if secretsFile != stdioGroup && !fileExists(secretsFile) {
+		return nil
 	}
 
-	file, err := writeTmpFile(contents)
+	plain, err := decryptPath(secretsFile)
 	if err != nil {
-		fmt.Println("Error opening temporary file")
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
 	}
-	cleanup := func() {
-		file.Close()
-		err := os.Remove(file.Name())
+
+	return plain
+}
+
+func decryptPath(path string) ([]byte, error) {
+	var info os.FileInfo
+	if cacheTTL > 0 && path != stdioGroup {
+		var err error
+		info, err = os.Stat(path)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error cleaning up temp file. Unencrypted secrets may have leaked ", err)
+			return nil, err
 		}
-	}
 
-	tmpEnc := fmt.Sprintf("%s.gpg", file.Name())
+		if plain, ok := readCache(path, info); ok {
+			return plain, nil
+		}
+	}
 
-	err = editFile(file.Name())
+	ciphertext, err := readCiphertext(path)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error editing secrets file: ", err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	_, stderr, err := gpg("--armor", "--cipher-algo", "AES256", "-c", "-o", tmpEnc, file.Name())
-	cleanup()
+	plain, err := decryptBlocks(ciphertext)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error encrypting temporary file: ", err, "\n", stderr)
-		os.Exit(1)
+		return nil, err
 	}
 
-	err = copyFile(tmpEnc, secretsFile)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Unable to move encrypted temp file to secrets dir: ", err)
-		cleanup()
-		os.Exit(1)
+	lockMemory(plain)
+	plain = bytes.TrimSpace(plain)
+
+	if info != nil {
+		err = writeCache(path, info, plain)
+		if err != nil && !quiet {
+			fmt.Fprintln(os.Stderr, "Warning: unable to cache secrets: ", err)
+		}
 	}
+
+	return plain, nil
 }
 
-func wrap() {
-	if len(execargs) < 1 {
-		fmt.Fprintln(os.Stderr, "Wrap requires at least an external program to run")
-		os.Exit(1)
+func groupFile(name string) string {
+	if obfuscateNames {
+		return filepath.Join(secretsDir, hashName(name)+secretsExt)
+	}
+
+	return filepath.Join(secretsDir, name+secretsExt)
+}
+
+// listGroups returns the names of all groups, including those in
+// subdirectories of the secrets directory.
+func listGroups() ([]string, error) {
+	if obfuscateNames {
+		return indexedGroups()
 	}
 
+	var groups []string
+
+	err := filepath.Walk(secretsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == secretsDir {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() || filepath.Ext(path) != secretsExt || path == indexFile() {
+			return nil
+		}
+
+		if checkSymlink(path) != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(secretsDir, path)
+		if err != nil {
+			return err
+		}
+
+		groups = append(groups, filepath.ToSlash(strings.TrimSuffix(rel, secretsExt)))
+		return nil
+	})
+
+	return groups, err
+}
+
+func decrypt() []byte {
 	ensureSecrets()
 
-	insertEnvironment(parseEnvironment(decrypt()))
+	return decryptFile()
+}
 
-	_, _, err := system(execargs[0], true, execargs[1:len(execargs)]...)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error executing external command: ", err)
+// decryptCommand prints the group's secrets, or writes them to -fifo.
+func decryptCommand() {
+	if format != "env" && format != "json" && format != "docker-env" && format != "systemd" && format != "k8s-secret" {
+		fmt.Fprintln(os.Stderr, "Unknown format", format, "for decrypt")
+		exit(1)
 	}
-}
 
-func writeTmpFile(contents string) (*os.File, error) {
-	tmpFile := filepath.Join(os.TempDir(), "unseal."+randChars())
+	if fifo != "" {
+		if format != "env" {
+			fmt.Fprintln(os.Stderr, "-fifo only writes the env format")
+			exit(1)
+		}
 
-	f, err := os.Create(tmpFile)
-	if err != nil {
-		return nil, err
+		secrets := filteredDecrypt()
+		err := writeFifo(fifo, secrets)
+		zero(secrets)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing secrets to named pipe: ", err)
+			exit(1)
+		}
+		return
 	}
 
-	err = f.Chmod(mode)
-	if err != nil {
-		f.Close()
-		return nil, err
+	ensureSecrets()
+	confirmTerminalOutput()
+
+	if format != "env" {
+		printFormatted()
+		return
 	}
 
-	_, err = f.WriteString(contents)
-	if err != nil {
-		f.Close()
-		return nil, err
+	if raw && envPrefix == "" && !sorted && !checksNonEmpty() && !inherits(group) {
+		decryptStream()
+		return
 	}
 
-	return f, err
+	secrets := filteredDecrypt()
+	if sorted {
+		unsorted := secrets
+		secrets = sortLines(unsorted)
+		zero(unsorted)
+	}
+	os.Stdout.Write(secrets)
+	fmt.Println()
+	zero(secrets)
 }
 
-func editFile(file string) error {
-	editor := os.Getenv("EDITOR")
-	if editor == "" {
-		editor = "vi"
+// printFormatted prints the group's variables as a JSON object, a Kubernetes
+// Secret, a docker env file or a systemd EnvironmentFile, following -format.
+func printFormatted() {
+	secrets := filteredDecrypt()
+	vars, err := parseEnvironment(secrets)
+	zero(secrets)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing secrets: ", err)
+		exit(1)
 	}
 
-	_, _, err := system(editor, true, file)
-	return err
+	var out []byte
+	switch format {
+	case "json":
+		out, err = json.MarshalIndent(vars, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error encoding secrets: ", err)
+			exit(1)
+		}
+	case "docker-env":
+		out = dockerEnv(vars)
+	case "systemd":
+		out = systemdEnv(vars)
+	default:
+		out, err = k8sSecretManifest(group, vars)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating Secret: ", err)
+			exit(1)
+		}
+	}
+	os.Stdout.Write(out)
+	fmt.Println()
+	zero(out)
 }
 
-func copyFile(oldpath, newpath string) error {
-	err := os.Rename(oldpath, newpath)
+// filteredDecrypt returns the secrets file's plaintext, limited to the lines
+// selected by -env-prefix.
+func filteredDecrypt() []byte {
+	secrets, err := inheritPlaintext(group, decrypt())
 	if err != nil {
-		byteArr, err2 := ioutil.ReadFile(oldpath)
-		if err2 != nil {
-			return err2
-		}
+		fmt.Fprintln(os.Stderr, "Error layering secrets: ", err)
+		exit(1)
+	}
 
-		err2 = ioutil.WriteFile(newpath, byteArr, mode)
-		if err2 == nil {
-			_ = os.Remove(oldpath)
-		} else {
-			_ = os.Remove(newpath)
+	if checksNonEmpty() {
+		vars, err := parseEnvironment(secrets)
+		if err != nil {
+			zero(secrets)
+			fmt.Fprintln(os.Stderr, "Error parsing secrets: ", err)
+			exit(1)
 		}
+		checkNonEmpty(vars)
+	}
 
-		return err2
+	if envPrefix == "" {
+		return secrets
 	}
-	return err
+
+	filtered, err := filterLines(secrets)
+	zero(secrets)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error filtering secrets: ", err)
+		exit(1)
+	}
+
+	return filtered
 }
 
-func insertEnvironment(vars map[string]string) {
-	for key, val := range vars {
-		os.Setenv(key, val)
+// decryptStream writes the decrypted secrets file to stdout, without holding
+// it in memory when the cipher supports streaming.
+func decryptStream() {
+	ensureSecrets()
+
+	err := decryptInto(os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error decrypting secrets file: ", err)
+		exit(1)
 	}
 }
 
-func parseEnvironment(raw string) map[string]string {
-	vars := make(map[string]string)
+func edit() {
+	ensureGroup()
 
-	for _, v := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
-		if v == strings.TrimSpace("") {
-			continue
-		}
+	if noClobber && fileExists(secretsFile) {
+		fmt.Fprintln(os.Stderr, "Secrets file", group, "already exists")
+		exit(1)
+	}
 
-		splitVar := strings.SplitN(v, "=", 2)
-		if len(splitVar) > 1 {
-			vars[splitVar[0]] = splitVar[1]
+	if fromClipboard {
+		editFromClipboard()
+		return
+	}
+
+	if requireTTY && !editorHasTerminal() {
+		fmt.Fprintln(os.Stderr, "Refusing to start an editor without a terminal, use import-all to encrypt files non-interactively")
+		exit(1)
+	}
+
+	existing := secretsFile == stdioGroup && !isTerminal(os.Stdin) || fileExists(secretsFile)
+	if !existing && confirmPassphrase {
+		err := newPassphrase()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to set passphrase: ", err)
+			exit(1)
 		}
 	}
 
-	return vars
-}
+	file, err := writeTmpFile(nil, tmpSuffix)
+	if err != nil {
+		fmt.Println("Error opening temporary file")
+		exit(1)
+	}
+	var once sync.Once
+	cleanup := func() {
+		once.Do(func() {
+			file.Close()
+			err := os.Remove(file.Name())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error cleaning up temp file. Unencrypted secrets may have leaked ", err)
+			}
+		})
+	}
+	defer cleanup()
+	defer trapSignals(cleanup)()
 
-func randChars() string {
-	buf := make([]byte, 4)
-	_, err := rand.Read(buf)
+	if existing {
+		err = decryptInto(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error decrypting secrets file: ", err)
+			cleanup()
+			exit(1)
+		}
+	}
+
+	err = editFile(file.Name())
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Unable to create temporary file")
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "Error editing secrets file: ", err)
+		cleanup()
+		exit(1)
 	}
 
-	return hex.EncodeToString(buf)
+	plain, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading temporary file: ", err)
+		cleanup()
+		exit(1)
+	}
+	vars, err := parseEnvironment(plain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning, secrets will not be usable: ", err)
+	}
+
+	err = saveSecrets(plain)
+	zero(plain)
+	cleanup()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	printSaved(len(vars))
+}
+
+// decryptInto writes the plaintext of the secrets file to f. When the cipher
+// can stream, the plaintext goes straight into f rather than through memory,
+// which matters for large groups.
+func decryptInto(f *os.File) error {
+	sc, ok := activeCipher.(streamCipher)
+	if !ok || cacheTTL > 0 {
+		plain := decryptFile()
+		defer zero(plain)
+
+		_, err := f.Write(plain)
+		return err
+	}
+
+	ciphertext, err := readCiphertext(secretsFile)
+	if err != nil {
+		return err
+	}
+
+	// gpg only decrypts the first of several appended messages.
+	if secretsExt == ".gpg" && bytes.Count(ciphertext, pgpMessageHeader) > 1 {
+		plain, err := decryptBlocks(ciphertext)
+		if err != nil {
+			return err
+		}
+		defer zero(plain)
+
+		_, err = f.Write(plain)
+		return err
+	}
+
+	return sc.decryptStream(bytes.NewReader(ciphertext), f)
+}
+
+// saveSecrets encrypts contents as the group's secrets file.
+func saveSecrets(contents []byte) error {
+	ciphertext, err := activeCipher.encrypt(contents)
+	if err != nil {
+		return fmt.Errorf("Error encrypting secrets: %v", err)
+	}
+
+	if secretsFile == stdioGroup {
+		_, err = os.Stdout.Write(ciphertext)
+		return err
+	}
+
+	if backup && fileExists(secretsFile) {
+		err = backupSecrets(secretsFile)
+		if err != nil {
+			return fmt.Errorf("Unable to back up secrets file: %v", err)
+		}
+	}
+
+	err = writeCiphertext(secretsFile, ciphertext)
+	if err != nil {
+		return err
+	}
+
+	if obfuscateNames && secretsFile == groupFile(group) {
+		err = addToIndex(group)
+		if err != nil {
+			return fmt.Errorf("Unable to update the group index: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// touch decrypts the group and encrypts it again unchanged, applying the
+// current backend settings.
+func touch() {
+	plain := decrypt()
+	defer zero(plain)
+
+	vars, err := parseEnvironment(plain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning, secrets will not be usable: ", err)
+	}
+
+	err = saveSecrets(plain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	printSaved(len(vars))
+}
+
+// editFromClipboard encrypts the clipboard as the group's contents without
+// starting an editor.
+func editFromClipboard() {
+	plain, err := readClipboard()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read the clipboard: ", err)
+		exit(1)
+	}
+	lockMemory(plain)
+	defer zero(plain)
+
+	contents := bytes.TrimSpace(plain)
+	if len(contents) == 0 {
+		fmt.Fprintln(os.Stderr, "The clipboard is empty")
+		exit(1)
+	}
+
+	vars, err := parseEnvironment(contents)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning, secrets will not be usable: ", err)
+	}
+
+	err = saveSecrets(contents)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	printSaved(len(vars))
+}
+
+// writeCiphertext replaces the file at path with ciphertext.
+func writeCiphertext(path string, ciphertext []byte) error {
+	file, err := writeTmpFile(ciphertext, "")
+	if err != nil {
+		return fmt.Errorf("Error opening temporary file: %v", err)
+	}
+	file.Close()
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = copyFile(file.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to move encrypted temp file to secrets dir, it is kept at %s: %v", file.Name(), err)
+	}
+	clearCache(path)
+
+	return nil
+}
+
+func printSaved(count int) {
+	if quiet || secretsFile == stdioGroup {
+		return
+	}
+
+	var size int64
+	info, err := os.Stat(secretsFile)
+	if err == nil {
+		size = info.Size()
+	}
+
+	fmt.Fprintf(os.Stderr, "Saved group '%s' (%d variables, %d bytes encrypted)\n", group, count, size)
+}
+
+func wrap() {
+	ensureGroup()
+
+	vars := make(map[string]string)
+	var directives directives
+	if onMissingGroup == "skip" && secretsFile != stdioGroup && !fileExists(secretsFile) {
+		if !quiet {
+			fmt.Fprintln(os.Stderr, "Group", group, "does not exist, running without secrets")
+		}
+	} else {
+		ensureSecrets()
+		vars, directives = groupEnvironment()
+		checkVarCount(vars)
+		checkNonEmpty(vars)
+	}
+
+	commands := splitCommands(execargs)
+	if len(commands) < 1 && len(directives.cmd) > 0 {
+		commands = [][]string{directives.cmd}
+	}
+
+	if len(commands) < 1 {
+		fmt.Fprintln(os.Stderr, "Wrap requires at least an external program to run or", groupCmdVar, "in the group")
+		exit(1)
+	}
+
+	if chdir != "" {
+		info, err := os.Stat(chdir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to use working directory: ", err)
+			exit(1)
+		}
+		if !info.IsDir() {
+			fmt.Fprintln(os.Stderr, "Working directory", chdir, "is not a directory")
+			exit(1)
+		}
+	}
+
+	if watch {
+		if len(commands) > 1 {
+			fmt.Fprintln(os.Stderr, "-watch can only restart a single program")
+			exit(1)
+		}
+
+		if preExec != "" || postExec != "" {
+			fmt.Fprintln(os.Stderr, "-pre-exec and -post-exec can't be used with -watch")
+			exit(1)
+		}
+
+		watchWrap(commands[0], vars)
+		return
+	}
+
+	removeFiles := writeFileVars(vars)
+	insertEnvironment(vars)
+
+	failed := runCommands(commands, vars)
+
+	removeFiles()
+	if failed != nil {
+		exitIfSignaled(failed)
+		exit(exitCode(failed))
+	}
+}
+
+// runCommands runs the commands in order between the -pre-exec and -post-exec
+// hooks and returns the first failure. The commands after a failure are
+// skipped unless -keep-going is given.
+func runCommands(commands [][]string, vars map[string]string) error {
+	var failed error
+	if preExec != "" {
+		failed = runHook("pre-exec", preExec, vars)
+	}
+
+	for _, args := range commands {
+		if failed != nil && !keepGoing {
+			break
+		}
+
+		err := runLogged(args, vars)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error executing external command: ", err)
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+
+	if postExec != "" {
+		err := runHook("post-exec", postExec, vars)
+		if failed == nil {
+			failed = err
+		}
+	}
+
+	return failed
+}
+
+// runHook runs the -pre-exec or -post-exec command, split on spaces, with the
+// same environment as the wrapped programs.
+func runHook(name, command string, vars map[string]string) error {
+	err := runLogged(strings.Fields(command), vars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error executing -%s command: %v\n", name, err)
+	}
+
+	return err
+}
+
+// splitCommands splits args into the commands separated by "--".
+func splitCommands(args []string) [][]string {
+	var commands [][]string
+
+	start := 0
+	for i := 0; i <= len(args); i++ {
+		if i < len(args) && args[i] != "--" {
+			continue
+		}
+
+		if i > start {
+			commands = append(commands, args[start:i])
+		}
+		start = i + 1
+	}
+
+	return commands
+}
+
+// writeFileVars writes each -file-var to its file and adds its KEY_FILE
+// variable to vars. The files must not exist yet. The returned function
+// removes them again, as does a signal asking unseal to terminate.
+func writeFileVars(vars map[string]string) func() {
+	var mu sync.Mutex
+	var paths []string
+	removeFiles := func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, path := range paths {
+			err := os.Remove(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error cleaning up secret file. Unencrypted secrets may have leaked ", err)
+			}
+		}
+		paths = nil
+	}
+	stopTrapping := trapSignals(removeFiles)
+
+	for _, mapping := range fileVars {
+		split := strings.SplitN(mapping, "=", 2)
+		key := split[0]
+
+		val, ok := vars[key]
+		if !ok {
+			removeFiles()
+			fmt.Fprintln(os.Stderr, "Variable", key, "given to -file-var is not in the group")
+			exit(1)
+		}
+
+		contents := []byte(val)
+		var f *os.File
+		var err error
+		if len(split) > 1 && split[1] != "" {
+			f, err = writeFile(split[1], contents)
+		} else {
+			f, err = writeTmpFile(contents, "")
+		}
+		zero(contents)
+		if err != nil {
+			removeFiles()
+			fmt.Fprintln(os.Stderr, "Error writing secret file for", key, ": ", err)
+			exit(1)
+		}
+		f.Close()
+
+		mu.Lock()
+		paths = append(paths, f.Name())
+		mu.Unlock()
+		vars[key+"_FILE"] = f.Name()
+	}
+
+	return func() {
+		stopTrapping()
+		removeFiles()
+	}
+}
+
+// checksNonEmpty reports whether -require-nonempty or -require-nonempty-keys
+// is given.
+func checksNonEmpty() bool {
+	return requireNonEmpty || requireNonEmptyKeys != ""
+}
+
+// checkNonEmpty fails if a variable named by -require-nonempty-keys is missing
+// or empty, or with -require-nonempty if any variable is empty.
+func checkNonEmpty(vars map[string]string) {
+	var empty []string
+	for key, val := range vars {
+		if requireNonEmpty && val == "" {
+			empty = append(empty, key)
+		}
+	}
+
+	for _, key := range strings.Split(requireNonEmptyKeys, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" && vars[key] == "" && !contains(empty, key) {
+			empty = append(empty, key)
+		}
+	}
+
+	if len(empty) > 0 {
+		sort.Strings(empty)
+		fmt.Fprintln(os.Stderr, "Group", group, "has empty or missing variables:", strings.Join(empty, ", "))
+		exit(1)
+	}
+}
+
+// checkVarCount guards against running the wrapped program without secrets,
+// which usually means a wrong passphrase or an empty group.
+func checkVarCount(vars map[string]string) {
+	if len(vars) < minVars {
+		fmt.Fprintln(os.Stderr, "Group", group, "has", len(vars), "variables, expected at least", minVars)
+		exit(1)
+	}
+
+	if len(vars) == 0 {
+		if requireVars {
+			fmt.Fprintln(os.Stderr, "Group", group, "has no variables to inject")
+			exit(1)
+		}
+
+		fmt.Fprintln(os.Stderr, "WARNING: group", group, "has no variables to inject")
+	}
+}
+
+func wrapCommand(args []string, vars map[string]string) *exec.Cmd {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "No command to run")
+		exit(1)
+	}
+
+	c := exec.Command(args[0], args[1:]...)
+	c.Dir = chdir
+
+	if cleanEnv {
+		c.Env = cleanEnvironment(vars)
+	}
+
+	if setsid {
+		err := newSession(c)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to use -setsid: ", err)
+			exit(1)
+		}
+	}
+
+	return c
+}
+
+// cleanEnvironment returns only the injected vars and the -env-passthrough
+// variables, for -clean-env. It is called after insertEnvironment so that the
+// passed through variables reflect -no-override.
+func cleanEnvironment(vars map[string]string) []string {
+	var env []string
+	passed := make(map[string]bool)
+
+	for _, names := range envPassthrough {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || passed[name] {
+				continue
+			}
+
+			if val, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+val)
+				passed[name] = true
+			}
+		}
+	}
+
+	for key, val := range vars {
+		if !passed[key] {
+			env = append(env, key+"="+val)
+		}
+	}
+	sort.Strings(env)
+
+	return env
+}
+
+// writeTmpFile writes contents to a new private file in the temp directory
+// whose name ends in suffix.
+func writeTmpFile(contents []byte, suffix string) (*os.File, error) {
+	return writeFile(filepath.Join(os.TempDir(), "unseal."+randChars()+suffix), contents)
+}
+
+// writeFile writes contents to a new private file at path, failing rather than
+// replacing a file that is already there.
+func writeFile(path string, contents []byte) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Chmod(mode)
+	if err == nil {
+		_, err = f.Write(contents)
+	}
+	if err != nil {
+		f.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// readCiphertext reads the secrets file at path, or stdin for stdioGroup.
+func readCiphertext(path string) ([]byte, error) {
+	if path == stdioGroup {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	return ioutil.ReadFile(path)
+}
+
+// openTerminal returns stdin when it is a terminal and the controlling
+// terminal otherwise, such as when stdin holds the ciphertext.
+func openTerminal() (*os.File, error) {
+	if isTerminal(os.Stdin) {
+		return os.Stdin, nil
+	}
+
+	return os.Open("/dev/tty")
+}
+
+// editorHasTerminal reports whether the editor would be attached to a
+// terminal rather than hang waiting for input that never comes.
+func editorHasTerminal() bool {
+	if secretsFile != stdioGroup {
+		return isTerminal(os.Stdin) && isTerminal(os.Stdout)
+	}
+
+	tty, err := openTerminal()
+	if err != nil {
+		return false
+	}
+	if tty != os.Stdin {
+		tty.Close()
+	}
+
+	return true
+}
+
+func editFile(file string) error {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	args := strings.Fields(editorArgs)
+	if len(args) == 0 && safeVim && isVim(editor) {
+		args = append(args, vimSafeArgs...)
+	}
+	args = append(args, file)
+
+	c := exec.Command(editor, args...)
+	if secretsFile == stdioGroup {
+		tty, err := openTerminal()
+		if err != nil {
+			return err
+		}
+		if tty != os.Stdin {
+			defer tty.Close()
+		}
+		c.Stdin = tty
+	}
+
+	atomic.StoreInt32(&editorRunning, 1)
+	defer atomic.StoreInt32(&editorRunning, 0)
+
+	_, _, err := run(c, true)
+	return err
+}
+
+// vimSafeArgs keep vim from writing plaintext to swap, undo and viminfo files.
+var vimSafeArgs = []string{"-n", "-i", "NONE", "--cmd", "set noundofile nobackup nowritebackup"}
+
+// isVim reports whether editor is vim or neovim, following symlinks such as
+// vi pointing to vim.
+func isVim(editor string) bool {
+	names := []string{filepath.Base(editor)}
+
+	path, err := exec.LookPath(editor)
+	if err == nil {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			names = append(names, filepath.Base(resolved))
+		}
+	}
+
+	for _, name := range names {
+		if strings.HasPrefix(name, "vim") || strings.HasPrefix(name, "nvim") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rename is os.Rename, replaceable to exercise copyFile's fallback.
+var rename = os.Rename
+
+func copyFile(oldpath, newpath string) error {
+	err := rename(oldpath, newpath)
+	if err == nil {
+		return nil
+	}
+
+	err = streamFile(oldpath, newpath)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(oldpath)
+}
+
+// streamFile copies oldpath to newpath, such as when they are on different
+// devices. The copy is written and synced to a temporary file next to newpath
+// and renamed over it, so newpath is never left truncated or half written and
+// the copy survives a power loss before the original is removed.
+func streamFile(oldpath, newpath string) error {
+	src, err := os.Open(oldpath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dir := filepath.Dir(newpath)
+	dst, err := ioutil.TempFile(dir, "."+filepath.Base(newpath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+
+	err = dst.Chmod(mode)
+	if err == nil {
+		_, err = io.Copy(dst, src)
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(dst.Name(), newpath)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// parseMergeOrder splits order into its sources, each of which may only
+// appear once.
+func parseMergeOrder(order string) ([]string, error) {
+	var sources []string
+	for _, source := range strings.Split(order, ",") {
+		source = strings.TrimSpace(source)
+		switch source {
+		case "host", "inherit", "group":
+		default:
+			return nil, fmt.Errorf("unknown source %q, must be host, inherit or group", source)
+		}
+
+		if contains(sources, source) {
+			return nil, fmt.Errorf("%s is given more than once", source)
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// insertEnvironment sets vars in the process environment, warning about (or
+// with -no-override skipping) any that are already set. The returned function
+// restores the environment to what it was before.
+func insertEnvironment(vars map[string]string) func() {
+	var overridden []string
+	previous := make(map[string]*string)
+
+	for key, val := range vars {
+		if old, ok := os.LookupEnv(key); ok {
+			overridden = append(overridden, key)
+			if noOverride {
+				continue
+			}
+			previous[key] = &old
+		} else {
+			previous[key] = nil
+		}
+
+		os.Setenv(key, val)
+	}
+
+	if len(overridden) > 0 && !quiet {
+		sort.Strings(overridden)
+		if noOverride {
+			fmt.Fprintln(os.Stderr, "Warning: not overriding existing variables:", strings.Join(overridden, ", "))
+		} else {
+			fmt.Fprintln(os.Stderr, "Warning: overriding existing variables:", strings.Join(overridden, ", "))
+		}
+	}
+
+	return func() {
+		for key, old := range previous {
+			if old == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *old)
+			}
+		}
+	}
+}
+
+// decryptEnvironment returns the group's variables layered with those of the
+// -inherit group when one is given, in -merge-order.
+func decryptEnvironment() map[string]string {
+	return layerEnvironment(false)
+}
+
+// layerEnvironment applies the sources in -merge-order, later ones overriding
+// earlier ones. With host, variables already set in the process environment
+// are dropped wherever host comes after the source that set them, so that the
+// host's value is kept.
+func layerEnvironment(host bool) map[string]string {
+	secrets := decrypt()
+	defer zero(secrets)
+
+	groupVars, err := parseEnvironment(secrets)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing secrets: ", err)
+		exit(1)
+	}
+
+	vars, err := layerVars(group, groupVars, host)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	return vars
+}
+
+// inherits reports whether the named group is layered over an -inherit group.
+func inherits(name string) bool {
+	return inherit != "" && inherit != name
+}
+
+// layerVars layers groupVars, the variables of the named group, as
+// layerEnvironment does.
+func layerVars(name string, groupVars map[string]string, host bool) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, source := range mergeSources {
+		var layer map[string]string
+
+		switch source {
+		case "host":
+			if host {
+				for key := range vars {
+					if _, ok := os.LookupEnv(key); ok {
+						delete(vars, key)
+					}
+				}
+			}
+		case "inherit":
+			if inherits(name) {
+				var err error
+				layer, err = loadVars(inherit)
+				if err != nil {
+					return nil, fmt.Errorf("Unable to load inherited group: %v", err)
+				}
+			}
+		case "group":
+			layer = groupVars
+		}
+
+		for key, val := range layer {
+			vars[key] = val
+		}
+	}
+
+	return vars, nil
+}
+
+// inheritPlaintext returns the plaintext of the named group layered with the
+// -inherit group in -merge-order, as sorted lines. Without a group to inherit
+// from plain is returned as is, otherwise it is zeroed.
+func inheritPlaintext(name string, plain []byte) ([]byte, error) {
+	if !inherits(name) {
+		return plain, nil
+	}
+	defer zero(plain)
+
+	groupVars, err := parseEnvironment(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := layerVars(name, groupVars, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatEnv(vars)
+}
+
+// loadVars decrypts and parses the named group.
+func loadVars(name string) (map[string]string, error) {
+	err := validateGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid group name: %v", err)
+	}
+
+	path := groupFile(name)
+	err = checkSymlink(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fileExists(path) {
+		return nil, fmt.Errorf("Group %s does not exist", name)
+	}
+
+	secrets, err := decryptPath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(secrets)
+
+	vars, err := parseEnvironment(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing secrets: %v", err)
+	}
+
+	return vars, nil
+}
+
+// parseEnvironment splits raw into KEY=value pairs. It works on the byte
+// slice in place so the caller can zero raw afterwards.
+func parseEnvironment(raw []byte) (map[string]string, error) {
+	if dotenvCompat {
+		return parseDotenv(raw)
+	}
+
+	vars := make(map[string]string)
+	lines := make(keyLines)
+
+	for i, v := range bytes.Split(raw, []byte("\n")) {
+		v = bytes.TrimSuffix(v, []byte("\r"))
+		if len(v) == 0 || isComment(v) {
+			continue
+		}
+
+		splitVar := bytes.SplitN(v, []byte(envSeparator), 2)
+		if len(splitVar) > 1 {
+			if bytes.IndexByte(v, 0) >= 0 {
+				return nil, fmt.Errorf("variable %q contains a NUL byte", splitVar[0])
+			}
+
+			key := string(splitVar[0])
+			vars[key] = string(splitVar[1])
+			lines[key] = append(lines[key], i+1)
+		}
+	}
+
+	err := lines.checkDuplicates()
+	if err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+// keyLines records the line numbers each variable is defined on.
+type keyLines map[string][]int
+
+// checkDuplicates warns about variables defined more than once, or fails
+// under -strict. The last definition is the one used.
+func (k keyLines) checkDuplicates() error {
+	var dups []string
+	for key, lines := range k {
+		if len(lines) < 2 {
+			continue
+		}
+
+		nums := make([]string, len(lines))
+		for i, n := range lines {
+			nums[i] = strconv.Itoa(n)
+		}
+		dups = append(dups, fmt.Sprintf("%s (lines %s)", key, strings.Join(nums, ", ")))
+	}
+
+	if len(dups) == 0 {
+		return nil
+	}
+	sort.Strings(dups)
+
+	if strict {
+		return fmt.Errorf("duplicate variables: %s", strings.Join(dups, "; "))
+	}
+
+	if !quiet {
+		fmt.Fprintln(os.Stderr, "Warning: duplicate variables, the last value wins:", strings.Join(dups, "; "))
+	}
+
+	return nil
+}
+
+// sortLines returns a copy of the variable lines in plain ordered by name, for
+// output that is the same however the file is laid out. Comments are dropped
+// and only the last definition of a variable is kept, as parseEnvironment
+// does.
+func sortLines(plain []byte) []byte {
+	lines := make(map[string][]byte)
+	for _, line := range bytes.Split(plain, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		i := bytes.Index(line, []byte(envSeparator))
+		if i < 0 || isComment(line) {
+			continue
+		}
+
+		lines[string(line[:i])] = line
+	}
+
+	keys := make([]string, 0, len(lines))
+	for key := range lines {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.Write(lines[key])
+	}
+
+	return buf.Bytes()
+}
+
+// filterLines returns a copy of the variable lines in plain whose names start
+// with -env-prefix. With -strip-prefix the prefix is removed from each name.
+func filterLines(plain []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	seen := make(map[string]bool)
+
+	for _, line := range bytes.Split(plain, []byte("\n")) {
+		i := bytes.Index(line, []byte(envSeparator))
+		if !bytes.HasPrefix(line, []byte(envPrefix)) || i < 0 {
+			continue
+		}
+
+		if stripPrefix {
+			line = line[len(envPrefix):]
+			i -= len(envPrefix)
+			key := string(line[:i])
+			if key == "" || seen[key] {
+				zero(buf.Bytes())
+				return nil, fmt.Errorf("Stripping prefix %q makes %q ambiguous", envPrefix, key)
+			}
+			seen[key] = true
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.Write(line)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// filterVars returns the variables whose names start with -env-prefix. With
+// -strip-prefix the prefix is removed from each name.
+func filterVars(vars map[string]string) (map[string]string, error) {
+	if envPrefix == "" {
+		return vars, nil
+	}
+
+	filtered := make(map[string]string)
+	for key, val := range vars {
+		if !strings.HasPrefix(key, envPrefix) {
+			continue
+		}
+
+		if stripPrefix {
+			key = strings.TrimPrefix(key, envPrefix)
+			if _, ok := filtered[key]; ok || key == "" {
+				return nil, fmt.Errorf("Stripping prefix %q makes %q ambiguous", envPrefix, key)
+			}
+		}
+		filtered[key] = val
+	}
+
+	return filtered, nil
+}
+
+func isComment(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(line), []byte("#"))
+}
+
+// zero overwrites b so plaintext does not linger in memory and releases any
+// lock held on it.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+
+	unlockMemory(b)
+}
+
+func randChars() string {
+	buf := make([]byte, 4)
+	_, err := rand.Read(buf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to create temporary file")
+		exit(1)
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 func printHelp() {
@@ -0,0 +1,830 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeCipher "encrypts" by reversing the plaintext behind a marker, so tests
+// can tell ciphertext apart from plaintext without a real backend.
+type fakeCipher struct{}
+
+var fakeMarker = []byte("FAKE\n")
+
+func (fakeCipher) ext() string {
+	return ".fake"
+}
+
+func (fakeCipher) encrypt(plaintext []byte) ([]byte, error) {
+	return append(append([]byte{}, fakeMarker...), reverse(plaintext)...), nil
+}
+
+func (fakeCipher) decrypt(ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, fakeMarker) {
+		return nil, errors.New("not encrypted by fakeCipher")
+	}
+
+	return reverse(ciphertext[len(fakeMarker):]), nil
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+
+	return r
+}
+
+// useSecretsDir points unseal at an empty secrets directory, using c to
+// encrypt, for the duration of the test.
+func useSecretsDir(t *testing.T, c cipher, name string) {
+	oldCipher, oldExt, oldDir, oldGroup, oldFile, oldQuiet := activeCipher, secretsExt, secretsDir, group, secretsFile, quiet
+	t.Cleanup(func() {
+		activeCipher, secretsExt, secretsDir, group, secretsFile, quiet = oldCipher, oldExt, oldDir, oldGroup, oldFile, oldQuiet
+	})
+
+	activeCipher = c
+	secretsExt = c.ext()
+	secretsDir = filepath.Join(t.TempDir(), ".secrets")
+	group = name
+	secretsFile = groupFile(name)
+	quiet = true
+}
+
+// writeScript writes an executable shell script for tests that run external
+// programs.
+func writeScript(t *testing.T, name, body string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on windows")
+	}
+
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestEditDecryptRoundTrip(t *testing.T) {
+	useSecretsDir(t, fakeCipher{}, "app/db")
+
+	editor := writeScript(t, "editor", `printf 'USER=admin\nPASS=hunter2\n' > "$1"`+"\n")
+	oldEditor, hadEditor := os.LookupEnv("EDITOR")
+	os.Setenv("EDITOR", editor)
+	defer func() {
+		if hadEditor {
+			os.Setenv("EDITOR", oldEditor)
+		} else {
+			os.Unsetenv("EDITOR")
+		}
+	}()
+
+	edit()
+
+	ciphertext, err := ioutil.ReadFile(secretsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, []byte("hunter2")) {
+		t.Fatal("secrets file contains plaintext")
+	}
+
+	plain, err := decryptPath(secretsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "USER=admin\nPASS=hunter2"
+	if string(plain) != want {
+		t.Errorf("decrypted %q, want %q", plain, want)
+	}
+}
+
+func TestGpgBackendWithStub(t *testing.T) {
+	oldBinary := gpgBinary
+	defer func() {
+		gpgBinary = oldBinary
+	}()
+
+	// The stub ignores its arguments and passes stdin through, which is
+	// enough to check how unseal drives gpg.
+	gpgBinary = writeScript(t, "gpg", "cat\n")
+	useSecretsDir(t, gpgCipher{compress: "none"}, "app")
+
+	err := saveSecrets([]byte("A=1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := decryptPath(secretsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plain) != "A=1" {
+		t.Errorf("decrypted %q, want %q", plain, "A=1")
+	}
+}
+
+func TestParseEnvironment(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want map[string]string
+	}{
+		{"simple", "A=1\nB=2", map[string]string{"A": "1", "B": "2"}},
+		{"crlf", "A=1\r\nB=2\r\n", map[string]string{"A": "1", "B": "2"}},
+		{"comments", "# note\n  # indented\nA=1", map[string]string{"A": "1"}},
+		{"blank lines", "\n\nA=1\n\n", map[string]string{"A": "1"}},
+		{"quotes kept", `A="x y"` + "\nB='z'", map[string]string{"A": `"x y"`, "B": "'z'"}},
+		{"equals in value", "A=b=c", map[string]string{"A": "b=c"}},
+		{"empty value", "A=", map[string]string{"A": ""}},
+		{"not an assignment", "junk\nA=1", map[string]string{"A": "1"}},
+	}
+
+	oldQuiet := quiet
+	quiet = true
+	defer func() {
+		quiet = oldQuiet
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEnvironment([]byte(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for key, val := range tt.want {
+				if got[key] != val {
+					t.Errorf("%s = %q, want %q", key, got[key], val)
+				}
+			}
+		})
+	}
+}
+
+func TestParseEnvironmentNul(t *testing.T) {
+	_, err := parseEnvironment([]byte("A=1\x002"))
+	if err == nil {
+		t.Error("expected an error for a value with a NUL byte")
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	err := ioutil.WriteFile(src, []byte("secret"), mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = copyFile(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkMoved(t, src, dst)
+}
+
+func TestCopyFileCrossDevice(t *testing.T) {
+	oldRename := rename
+	defer func() {
+		rename = oldRename
+	}()
+	rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.New("invalid cross-device link")}
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	err := ioutil.WriteFile(src, []byte("secret"), mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = copyFile(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkMoved(t, src, dst)
+}
+
+func checkMoved(t *testing.T, src, dst string) {
+	t.Helper()
+
+	if fileExists(src) {
+		t.Error("source file still exists")
+	}
+
+	contents, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "secret" {
+		t.Errorf("destination contains %q, want %q", contents, "secret")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("destination mode is %v, want %v", info.Mode().Perm(), os.FileMode(mode))
+	}
+}
+
+func TestRunCommandsFailure(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	ok := writeScript(t, "ok", `echo ok >> "$1"`+"\n")
+	fail3 := writeScript(t, "fail3", `echo fail3 >> "$1"; exit 3`+"\n")
+	fail4 := writeScript(t, "fail4", `echo fail4 >> "$1"; exit 4`+"\n")
+	commands := [][]string{{ok, log}, {fail3, log}, {fail4, log}, {ok, log}}
+
+	tests := []struct {
+		keepGoing bool
+		ran       string
+	}{
+		{false, "ok\nfail3\n"},
+		{true, "ok\nfail3\nfail4\nok\n"},
+	}
+
+	oldKeepGoing := keepGoing
+	defer func() {
+		keepGoing = oldKeepGoing
+	}()
+
+	for _, tt := range tests {
+		os.Remove(log)
+		keepGoing = tt.keepGoing
+
+		err := runCommands(commands, nil)
+		if err == nil {
+			t.Fatalf("keep going %v: expected an error", tt.keepGoing)
+		}
+		if code := exitCode(err); code != 3 {
+			t.Errorf("keep going %v: exit code %d, want 3", tt.keepGoing, code)
+		}
+
+		ran, err := ioutil.ReadFile(log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(ran) != tt.ran {
+			t.Errorf("keep going %v: ran %q, want %q", tt.keepGoing, ran, tt.ran)
+		}
+	}
+}
+
+func TestCopyFileCrossDeviceKeepsDestination(t *testing.T) {
+	oldRename := rename
+	defer func() {
+		rename = oldRename
+	}()
+	rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.New("invalid cross-device link")}
+	}
+
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst")
+	err := ioutil.WriteFile(dst, []byte("original"), mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading a directory fails after the copy has started.
+	err = copyFile(t.TempDir(), dst)
+	if err == nil {
+		t.Fatal("expected an error copying a directory")
+	}
+
+	contents, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "original" {
+		t.Errorf("destination changed to %q", contents)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the destination in %s, found %d files", dir, len(entries))
+	}
+}
+
+func TestWriteFileExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	err := ioutil.WriteFile(path, []byte("mine"), mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = writeFile(path, []byte("secret"))
+	if !os.IsExist(err) {
+		t.Fatalf("expected the existing file to be refused, got %v", err)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "mine" {
+		t.Errorf("existing file changed to %q", contents)
+	}
+}
+
+func TestWriteFileVars(t *testing.T) {
+	oldFileVars := fileVars
+	defer func() {
+		fileVars = oldFileVars
+	}()
+
+	path := filepath.Join(t.TempDir(), "key")
+	fileVars = []string{"KEY=" + path}
+	vars := map[string]string{"KEY": "secret"}
+
+	removeFiles := writeFileVars(vars)
+	if vars["KEY_FILE"] != path {
+		t.Errorf("KEY_FILE is %q, want %q", vars["KEY_FILE"], path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("file mode is %v, want %v", info.Mode().Perm(), os.FileMode(mode))
+	}
+
+	removeFiles()
+	if fileExists(path) {
+		t.Error("file still exists after removing the files")
+	}
+}
+
+func TestFindRecipientsFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not checked on windows")
+	}
+	useSecretsDir(t, fakeCipher{}, "team/app")
+
+	dir := filepath.Join(secretsDir, "team")
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A file above the secrets directory is never used.
+	above := filepath.Join(filepath.Dir(secretsDir), recipientsFileName)
+	err = ioutil.WriteFile(above, []byte("age1above\n"), mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := findRecipientsFile(dir)
+	if err != nil || path != "" {
+		t.Fatalf("found %q (%v) above the secrets directory", path, err)
+	}
+
+	want := filepath.Join(secretsDir, recipientsFileName)
+	err = ioutil.WriteFile(want, []byte("age1team\n"), mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err = findRecipientsFile(dir)
+	if err != nil || path != want {
+		t.Fatalf("found %q (%v), want %q", path, err, want)
+	}
+
+	err = os.Chmod(want, 0622)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = findRecipientsFile(dir)
+	if err == nil {
+		t.Error("expected a recipients file writable by others to be refused")
+	}
+}
+
+func TestEnvSeparator(t *testing.T) {
+	oldSeparator := envSeparator
+	defer func() {
+		envSeparator = oldSeparator
+	}()
+
+	tests := []struct {
+		separator string
+		json      string
+		env       string
+	}{
+		{"=", `{"B":"2","A":"x=y"}`, "A=x=y\nB=2\n"},
+		{": ", `{"B":"2","A":"x: y"}`, "A: x: y\nB: 2\n"},
+		{":", `{"A":"=1"}`, "A:=1\n"},
+	}
+
+	for _, tt := range tests {
+		envSeparator = tt.separator
+
+		env, err := jsonToEnv([]byte(tt.json))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.separator, err)
+		}
+		if string(env) != tt.env {
+			t.Errorf("%q: jsonToEnv gave %q, want %q", tt.separator, env, tt.env)
+		}
+
+		vars, err := parseEnvironment(env)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.separator, err)
+		}
+
+		var want map[string]string
+		err = json.Unmarshal([]byte(tt.json), &want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(vars, want) {
+			t.Errorf("%q: parsed %v, want %v", tt.separator, vars, want)
+		}
+	}
+}
+
+func TestSplitComment(t *testing.T) {
+	tests := []struct {
+		in      string
+		value   string
+		comment string
+	}{
+		{"value", "value", ""},
+		{"value # note", "value", " # note"},
+		{"value\t# note", "value", "\t# note"},
+		{"a#b", "a#b", ""},
+		{`"a # b"`, `"a # b"`, ""},
+		{`"a # b" # note`, `"a # b"`, " # note"},
+		{`'a#b'#note`, `'a#b'`, "#note"},
+		{"`a # b` # note", "`a # b`", " # note"},
+		{`"open # note`, `"open`, " # note"},
+	}
+
+	for _, tt := range tests {
+		value, comment := splitComment([]byte(tt.in))
+		if string(value) != tt.value || string(comment) != tt.comment {
+			t.Errorf("splitComment(%q) = %q, %q, want %q, %q", tt.in, value, comment, tt.value, tt.comment)
+		}
+	}
+}
+
+func TestKeptComment(t *testing.T) {
+	tests := []struct {
+		old  string
+		new  string
+		kept string
+	}{
+		{"=old # note", "=new", " # note"},
+		{"=old # note\r", "=new", " # note"},
+		{"=old # note", "=new # mine", ""},
+		{"=old", "=new", ""},
+		{`="a # b"`, "=new", ""},
+		{`="a # b" # note`, `="c # d"`, " # note"},
+	}
+
+	for _, tt := range tests {
+		kept := keptComment([]byte(tt.old), []byte(tt.new))
+		if string(kept) != tt.kept {
+			t.Errorf("keptComment(%q, %q) = %q, want %q", tt.old, tt.new, kept, tt.kept)
+		}
+	}
+}
+
+func TestLintTrailingComment(t *testing.T) {
+	oldCompat := dotenvCompat
+	defer func() {
+		dotenvCompat = oldCompat
+	}()
+
+	tests := []struct {
+		compat     bool
+		line       string
+		advisories int
+	}{
+		{true, "A=value # note", 0},
+		{true, `A="a # b" # note`, 0},
+		{true, "A=two words # note", 1},
+		{false, "A=value # note", 1},
+	}
+
+	for _, tt := range tests {
+		dotenvCompat = tt.compat
+		advisories := lintEnvironment([]byte(tt.line))
+		if len(advisories) != tt.advisories {
+			t.Errorf("compat %v, %q: got advisories %q, want %d", tt.compat, tt.line, advisories, tt.advisories)
+		}
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	useSecretsDir(t, fakeCipher{}, "app")
+
+	err := os.MkdirAll(secretsDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := fakeCipher{}.encrypt([]byte("URL=postgres://db\nCHAIN=@app:PLAIN\nLOOP=@app:LOOP\n"))
+	err = ioutil.WriteFile(groupFile("base"), base, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key     string
+		val     string
+		want    string
+		wantErr bool
+	}{
+		{"PLAIN", "value", "value", false},
+		{"URL", "@base:URL", "postgres://db", false},
+		{"CHAINED", "@base:CHAIN", "value", false},
+		{"ESCAPED", "@@base:URL", "@base:URL", false},
+		{"EMAIL", "user@base:URL", "user@base:URL", false},
+		{"SUFFIX", "@base:URL extra", "@base:URL extra", false},
+		{"MISSING_KEY", "@base:NOPE", "", true},
+		{"MISSING_GROUP", "@nope:URL", "", true},
+		{"LOOP", "@base:LOOP", "", true},
+		{"SELF", "@app:SELF", "", true},
+	}
+
+	vars := make(map[string]string)
+	for _, tt := range tests {
+		vars[tt.key] = tt.val
+	}
+
+	for _, tt := range tests {
+		r := resolver{groups: map[string]map[string]string{group: vars}}
+		got, err := r.resolve(tt.val, []string{group + ":" + tt.key})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", tt.key, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.key, err)
+		} else if got != tt.want {
+			t.Errorf("%s: resolved to %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+// filter runs f with input on stdin and returns what it wrote to stdout.
+func filter(t *testing.T, f func(), input []byte) []byte {
+	t.Helper()
+
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	err := ioutil.WriteFile(in, input, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, err := os.Open(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	stdout, err := os.Create(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	oldStdin, oldStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, stdout
+	f()
+	os.Stdin, os.Stdout = oldStdin, oldStdout
+
+	output, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return output
+}
+
+func TestGitFilter(t *testing.T) {
+	useSecretsDir(t, fakeCipher{}, "app")
+	oldArgs := execargs
+	defer func() {
+		execargs = oldArgs
+	}()
+	execargs = nil
+
+	tests := []struct {
+		name  string
+		plain string
+	}{
+		{"empty", ""},
+		{"single line", "A=1"},
+		{"lines", "A=1\nB=two words\n"},
+	}
+
+	for _, tt := range tests {
+		ciphertext := filter(t, gitClean, []byte(tt.plain))
+		if len(tt.plain) > 0 && bytes.Contains(ciphertext, []byte(tt.plain)) {
+			t.Errorf("%s: clean left the plaintext readable: %q", tt.name, ciphertext)
+		}
+
+		plain := filter(t, gitSmudge, ciphertext)
+		if string(plain) != tt.plain {
+			t.Errorf("%s: smudge gave %q, want %q", tt.name, plain, tt.plain)
+		}
+	}
+
+	if plain := filter(t, gitSmudge, nil); len(plain) != 0 {
+		t.Errorf("smudge of an empty file gave %q", plain)
+	}
+}
+
+func TestDecryptsTo(t *testing.T) {
+	useSecretsDir(t, fakeCipher{}, "app")
+	ciphertext, _ := fakeCipher{}.encrypt([]byte("A=1\n"))
+
+	tests := []struct {
+		ciphertext []byte
+		plain      string
+		want       bool
+	}{
+		{ciphertext, "A=1\n", true},
+		{ciphertext, "A=2\n", false},
+		{ciphertext, "A=1", false},
+		{[]byte("garbage"), "A=1\n", false},
+	}
+
+	for _, tt := range tests {
+		if got := decryptsTo(tt.ciphertext, []byte(tt.plain)); got != tt.want {
+			t.Errorf("decryptsTo(%q, %q) = %v, want %v", tt.ciphertext, tt.plain, got, tt.want)
+		}
+	}
+}
+
+func TestScrubWriter(t *testing.T) {
+	vars := map[string]string{
+		"TOKEN":   "hunter22",
+		"LONGER":  "hunter22andmore",
+		"OVERLAP": "r22xyz",
+		"SHORT":   "abc",
+	}
+
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"whole", []string{"pass hunter22\n"}, "pass ***\n"},
+		{"split", []string{"pass hun", "ter22\n"}, "pass ***\n"},
+		{"bytes", strings.Split("x hunter22 y", ""), "x *** y"},
+		{"longer first", []string{"hunter22andmore"}, "***"},
+		{"longer split", []string{"hunter22and", "more!"}, "***!"},
+		{"longer abandoned", []string{"hunter22and", "less"}, "***andless"},
+		{"prefix at end", []string{"ends with hunt"}, "ends with hunt"},
+		{"prefix then other", []string{"hunt", "ing"}, "hunting"},
+		{"overlap", []string{"a hunter22", "xyz"}, "a ***xyz"},
+		{"too short", []string{"abc"}, "abc"},
+		{"twice", []string{"hunter22 hun", "ter22"}, "*** ***"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := newScrubWriter(&buf, vars)
+		for _, chunk := range tt.chunks {
+			n, err := w.Write([]byte(chunk))
+			if err != nil || n != len(chunk) {
+				t.Fatalf("%s: Write(%q) = %d, %v", tt.name, chunk, n, err)
+			}
+		}
+
+		err := w.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: wrote %q, want %q", tt.name, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	tests := []struct {
+		raw      []byte
+		encoding string
+		encoded  string
+	}{
+		{[]byte{}, "base64", ""},
+		{[]byte("secret"), "base64", "c2VjcmV0"},
+		{[]byte{0, 0xff, '\n', '='}, "base64", "AP8KPQ=="},
+		{[]byte("secret"), "hex", "736563726574"},
+		{[]byte{0, 0xff, '\n'}, "hex", "00ff0a"},
+	}
+
+	for _, tt := range tests {
+		encoded, err := encodeValue(tt.raw, tt.encoding)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tt.encoding, tt.raw, err)
+		}
+		if string(encoded) != tt.encoded {
+			t.Errorf("%s %q: encoded to %q, want %q", tt.encoding, tt.raw, encoded, tt.encoded)
+		}
+
+		decoded, err := decodeValue(string(encoded), tt.encoding)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tt.encoding, encoded, err)
+		}
+		if !bytes.Equal(decoded, tt.raw) {
+			t.Errorf("%s: decoded %q, want %q", tt.encoding, decoded, tt.raw)
+		}
+	}
+
+	if _, err := encodeValue([]byte("x"), "rot13"); err == nil {
+		t.Error("expected an error encoding with an unknown encoding")
+	}
+	if _, err := decodeValue("not hex", "hex"); err == nil {
+		t.Error("expected an error decoding invalid hex")
+	}
+	if _, err := decodeValue("%%%", "base64"); err == nil {
+		t.Error("expected an error decoding invalid base64")
+	}
+}
+
+func TestInheritPlaintext(t *testing.T) {
+	useSecretsDir(t, fakeCipher{}, "app")
+	oldInherit, oldSources := inherit, mergeSources
+	defer func() {
+		inherit, mergeSources = oldInherit, oldSources
+	}()
+
+	err := os.MkdirAll(secretsDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := fakeCipher{}.encrypt([]byte("BASE=1\nFOO=base\n"))
+	err = ioutil.WriteFile(groupFile("base"), base, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		inherit string
+		sources []string
+		want    string
+	}{
+		{"", []string{"host", "inherit", "group"}, "FOO=app\n"},
+		{"app", []string{"host", "inherit", "group"}, "FOO=app\n"},
+		{"base", []string{"host", "inherit", "group"}, "BASE=1\nFOO=app\n"},
+		{"base", []string{"group", "inherit"}, "BASE=1\nFOO=base\n"},
+	}
+
+	for _, tt := range tests {
+		inherit, mergeSources = tt.inherit, tt.sources
+
+		plain, err := inheritPlaintext("app", []byte("FOO=app\n"))
+		if err != nil {
+			t.Fatalf("inherit %q: %v", tt.inherit, err)
+		}
+		if string(plain) != tt.want {
+			t.Errorf("inherit %q, order %v: got %q, want %q", tt.inherit, tt.sources, plain, tt.want)
+		}
+	}
+
+	inherit = "missing"
+	if _, err := inheritPlaintext("app", []byte("FOO=app\n")); err == nil {
+		t.Error("expected an error inheriting from a missing group")
+	}
+}
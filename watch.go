@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const watchInterval = time.Second
+const stopTimeout = 10 * time.Second
+
+// watchWrap runs the external program and relaunches it with a freshly
+// decrypted environment whenever the secrets file changes. It returns once the
+// program exits on its own.
+func watchWrap(args []string, vars map[string]string) {
+	for {
+		removeFiles := writeFileVars(vars)
+		restoreEnvironment := insertEnvironment(vars)
+
+		c := wrapCommand(args, vars)
+		var flush func()
+		c.Stdin = os.Stdin
+		c.Stdout, c.Stderr, flush = outputWriters(vars)
+
+		last, _ := os.Stat(secretsFile)
+
+		start := time.Now()
+		err := c.Start()
+		if err != nil {
+			logExec(args, start, err)
+			removeFiles()
+			fmt.Fprintln(os.Stderr, "Error executing external command: ", err)
+			exit(1)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Wait()
+		}()
+
+		changed, err := waitForChange(last, done)
+		if !changed {
+			logExec(args, start, err)
+			flush()
+			removeFiles()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error executing external command: ", err)
+				exitIfSignaled(err)
+				exit(exitCode(err))
+			}
+			return
+		}
+
+		if !quiet {
+			fmt.Fprintln(os.Stderr, "Secrets file changed, restarting", args[0])
+		}
+		logExec(args, start, stopProcess(c, done))
+		flush()
+		removeFiles()
+		restoreEnvironment()
+
+		vars, _ = groupEnvironment()
+		checkVarCount(vars)
+		checkNonEmpty(vars)
+	}
+}
+
+// waitForChange polls the secrets file until it differs from last or the
+// program exits. It reports whether the file changed, or the program's error
+// if it exited.
+func waitForChange(last os.FileInfo, done <-chan error) (bool, error) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			return false, err
+		case <-ticker.C:
+			info, err := os.Stat(secretsFile)
+			if err != nil {
+				continue
+			}
+
+			if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+				return true, nil
+			}
+		}
+	}
+}
+
+// stopProcess asks the program to terminate and kills it if it has not
+// exited within stopTimeout. It returns the program's error from exiting.
+func stopProcess(c *exec.Cmd, done <-chan error) error {
+	err := c.Process.Signal(syscall.SIGTERM)
+	if err != nil {
+		_ = c.Process.Kill()
+	}
+
+	select {
+	case err = <-done:
+	case <-time.After(stopTimeout):
+		_ = c.Process.Kill()
+		err = <-done
+	}
+
+	return err
+}